@@ -1,18 +1,30 @@
 package cache
 
 import (
-	"bytes"
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"imageproxy/internal/metrics"
 	"imageproxy/internal/storage"
 )
 
-// LRUCache реализация LRU кэша.
+// tracer создает spans операций кэша и хранилища.
+var tracer = otel.Tracer("imageproxy/internal/cache")
+
+// LRUCache реализация LRU кэша. Кэш хранит только порядок ключей, значения
+// читаются из хранилища и пишутся в него потоком.
 type LRUCache struct {
+	// name имя кэша в метриках и трассах
+	name     string
 	capacity int
 	mu       sync.Mutex
 	list     *list.List
@@ -21,12 +33,13 @@ type LRUCache struct {
 }
 
 type cacheItem struct {
-	key   string
-	value []byte
+	key string
 }
 
-func NewLRUCache(capacity int, storage storage.Storage) *LRUCache {
+// NewLRUCache создает кэш. Имя name различает кэши в метриках и трассах.
+func NewLRUCache(name string, capacity int, storage storage.Storage) *LRUCache {
 	return &LRUCache{
+		name:     name,
 		capacity: capacity,
 		list:     list.New(),
 		items:    make(map[string]*list.Element),
@@ -34,56 +47,108 @@ func NewLRUCache(capacity int, storage storage.Storage) *LRUCache {
 	}
 }
 
+// Name возвращает имя кэша.
+func (c *LRUCache) Name() string {
+	return c.name
+}
+
+// Get возвращает поток значения из хранилища.
 func (c *LRUCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	return lookup(ctx, c, key, c.storage.Get)
+}
 
-	if elem, ok := c.items[key]; ok {
-		c.list.MoveToFront(elem)
-		item := elem.Value.(*cacheItem)
-		return io.NopCloser(bytes.NewReader(item.value)), nil
+// Open возвращает значение, которое можно читать с любой позиции, например
+// для ответа на запрос диапазона.
+func (c *LRUCache) Open(ctx context.Context, key string) (storage.Reader, error) {
+	return lookup(ctx, c, key, c.storage.Open)
+}
+
+// lookup открывает значение функцией open и отмечает использование ключа.
+// Значение, найденное в хранилище, но не в кэше, например после
+// перезапуска, добавляется в кэш.
+func lookup[T io.Closer](ctx context.Context, c *LRUCache, key string,
+	open func(context.Context, string) (T, error),
+) (T, error) {
+	ctx, span := c.startSpan(ctx, "cache.get")
+	defer span.End()
+
+	readCtx, readSpan := c.startSpan(ctx, "storage.read")
+	value, err := open(readCtx, key)
+	readSpan.End()
+	if errors.Is(err, os.ErrNotExist) {
+		metrics.CacheMisses.WithLabelValues(c.name).Inc()
+		span.SetAttributes(attribute.Bool("cache.hit", false))
+		return value, err
+	} else if err != nil {
+		return value, err
 	}
+	metrics.CacheHits.WithLabelValues(c.name).Inc()
+	span.SetAttributes(attribute.Bool("cache.hit", true))
 
-	data, err := c.storage.Get(ctx, key)
-	if err != nil {
-		return nil, err
+	if err := c.add(ctx, key); err != nil {
+		value.Close()
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte) error {
+	ctx, span := c.startSpan(ctx, "cache.set")
+	defer span.End()
+
+	if err := c.writeStorage(ctx, key, value); err != nil {
+		return err
 	}
-	defer data.Close()
+	return c.add(ctx, key)
+}
 
-	value, err := io.ReadAll(data)
+// Create начинает потоковую запись значения. Ключ появляется в кэше после
+// Commit.
+func (c *LRUCache) Create(ctx context.Context, key string) (storage.Writer, error) {
+	w, err := c.storage.Create(ctx, key)
 	if err != nil {
 		return nil, err
 	}
+	return &cacheWriter{Writer: w, cache: c, ctx: ctx, key: key}, nil
+}
 
-	item := &cacheItem{key: key, value: value}
-	elem := c.list.PushFront(item)
-	c.items[key] = elem
+func (c *LRUCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if c.list.Len() > c.capacity {
-		if err := c.removeOldest(ctx); err != nil {
-			return nil, err
-		}
+	if elem, ok := c.items[key]; ok {
+		c.list.Remove(elem)
+		delete(c.items, key)
 	}
 
-	return io.NopCloser(bytes.NewReader(value)), nil
+	return c.storage.Delete(ctx, key)
 }
 
-func (c *LRUCache) Set(ctx context.Context, key string, value []byte) error {
+// Stat возвращает сведения о значении в хранилище кэша, не отмечая его
+// использование.
+func (c *LRUCache) Stat(ctx context.Context, key string) (storage.Entry, error) {
+	return c.storage.Stat(ctx, key)
+}
+
+// List возвращает значения в хранилище кэша, в том числе сохраненные до
+// запуска. Если хранилище общее с другими кэшами, в списке есть и их
+// значения, поэтому кэшам дают части хранилища через storage.WithPrefix.
+func (c *LRUCache) List(ctx context.Context) ([]storage.Entry, error) {
+	return c.storage.List(ctx)
+}
+
+// add отмечает ключ как последний использованный и вытесняет лишние.
+func (c *LRUCache) add(ctx context.Context, key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if err := c.storage.Set(ctx, key, value); err != nil {
-		return err
-	}
-
 	if elem, ok := c.items[key]; ok {
 		c.list.MoveToFront(elem)
-		elem.Value.(*cacheItem).value = value
 		return nil
 	}
 
-	item := &cacheItem{key: key, value: value}
-	elem := c.list.PushFront(item)
+	elem := c.list.PushFront(&cacheItem{key: key})
 	c.items[key] = elem
 
 	for c.list.Len() > c.capacity {
@@ -95,24 +160,49 @@ func (c *LRUCache) Set(ctx context.Context, key string, value []byte) error {
 	return nil
 }
 
-func (c *LRUCache) Delete(ctx context.Context, key string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *LRUCache) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("cache", c.name)))
+}
 
-	if elem, ok := c.items[key]; ok {
-		c.list.Remove(elem)
-		delete(c.items, key)
+// writeStorage записывает значение в хранилище.
+func (c *LRUCache) writeStorage(ctx context.Context, key string, value []byte) error {
+	ctx, span := c.startSpan(ctx, "storage.write")
+	defer span.End()
+
+	if err := c.storage.Set(ctx, key, value); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "storage write failed")
+		return err
 	}
+	return nil
+}
 
-	return c.storage.Delete(ctx, key)
+// cacheWriter добавляет ключ в кэш после сохранения значения в хранилище.
+type cacheWriter struct {
+	storage.Writer
+	cache *LRUCache
+	ctx   context.Context
+	key   string
 }
 
+func (w *cacheWriter) Commit() error {
+	_, span := w.cache.startSpan(w.ctx, "storage.write")
+	defer span.End()
+
+	if err := w.Writer.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "storage write failed")
+		return err
+	}
+	return w.cache.add(w.ctx, w.key)
+}
 func (c *LRUCache) removeOldest(ctx context.Context) error {
 	elem := c.list.Back()
 	if elem != nil {
 		item := elem.Value.(*cacheItem)
 		delete(c.items, item.key)
 		c.list.Remove(elem)
+		metrics.CacheEvictions.WithLabelValues(c.name).Inc()
 		if err := c.storage.Delete(ctx, item.key); err != nil {
 			return fmt.Errorf("failed to delete from storage: %w", err)
 		}
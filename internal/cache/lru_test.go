@@ -5,10 +5,15 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"imageproxy/internal/metrics"
+	"imageproxy/internal/storage"
 )
 
 // MockStorage правильная реализация Storage для тестов.
@@ -29,26 +34,68 @@ func (m *MockStorage) Set(ctx context.Context, key string, data []byte) error {
 	return args.Error(0)
 }
 
+func (m *MockStorage) Open(ctx context.Context, key string) (storage.Reader, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return mockReader{bytes.NewReader(args.Get(0).([]byte))}, args.Error(1)
+}
+
+func (m *MockStorage) Create(ctx context.Context, key string) (storage.Writer, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(storage.Writer), args.Error(1)
+}
+
 func (m *MockStorage) Delete(ctx context.Context, key string) error {
 	args := m.Called(ctx, key)
 	return args.Error(0)
 }
 
+func (m *MockStorage) Stat(ctx context.Context, key string) (storage.Entry, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(storage.Entry), args.Error(1)
+}
+
+func (m *MockStorage) List(ctx context.Context) ([]storage.Entry, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]storage.Entry), args.Error(1)
+}
+
 func (m *MockStorage) Size() int {
 	args := m.Called()
 	return args.Int(0)
 }
 
+func (m *MockStorage) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+type mockReader struct {
+	*bytes.Reader
+}
+
+func (mockReader) Close() error {
+	return nil
+}
+
 func TestLRUCache_Eviction(t *testing.T) {
 	ctx := context.Background()
 	mockStorage := new(MockStorage)
-	cache := NewLRUCache(2, mockStorage)
+	cache := NewLRUCache("test", 2, mockStorage)
 
 	// Настройка моков
-	mockStorage.On("Set", ctx, "key1", []byte("value1")).Return(nil)
-	mockStorage.On("Set", ctx, "key2", []byte("value2")).Return(nil)
-	mockStorage.On("Set", ctx, "key3", []byte("value3")).Return(nil)
-	mockStorage.On("Delete", ctx, "key1").Return(nil)
+	mockStorage.On("Set", mock.Anything, "key1", []byte("value1")).Return(nil)
+	mockStorage.On("Set", mock.Anything, "key2", []byte("value2")).Return(nil)
+	mockStorage.On("Set", mock.Anything, "key3", []byte("value3")).Return(nil)
+	mockStorage.On("Delete", mock.Anything, "key1").Return(nil)
 	mockStorage.On("Size").Return(0)
 
 	// Заполнение кэша
@@ -59,20 +106,20 @@ func TestLRUCache_Eviction(t *testing.T) {
 	assert.NoError(t, cache.Set(ctx, "key3", []byte("value3")))
 
 	// Проверка вызовов
-	mockStorage.AssertCalled(t, "Delete", ctx, "key1")
+	mockStorage.AssertCalled(t, "Delete", mock.Anything, "key1")
 }
 
 func TestLRUCache_GetUpdatesLRU(t *testing.T) {
 	ctx := context.Background()
 	mockStorage := new(MockStorage)
-	cache := NewLRUCache(2, mockStorage)
+	cache := NewLRUCache("test", 2, mockStorage)
 
 	// Настройка моков
-	mockStorage.On("Set", ctx, "key1", []byte("value1")).Return(nil)
-	mockStorage.On("Set", ctx, "key2", []byte("value2")).Return(nil)
-	mockStorage.On("Set", ctx, "key3", []byte("value3")).Return(nil)
-	mockStorage.On("Delete", ctx, "key2").Return(nil)
-	mockStorage.On("Get", ctx, "key1").Return([]byte("value1"), nil)
+	mockStorage.On("Set", mock.Anything, "key1", []byte("value1")).Return(nil)
+	mockStorage.On("Set", mock.Anything, "key2", []byte("value2")).Return(nil)
+	mockStorage.On("Set", mock.Anything, "key3", []byte("value3")).Return(nil)
+	mockStorage.On("Delete", mock.Anything, "key2").Return(nil)
+	mockStorage.On("Get", mock.Anything, "key1").Return([]byte("value1"), nil)
 	mockStorage.On("Size").Return(0)
 
 	// Заполнение кэша
@@ -86,18 +133,18 @@ func TestLRUCache_GetUpdatesLRU(t *testing.T) {
 	// Добавление нового элемента (должен вытеснить key2)
 	assert.NoError(t, cache.Set(ctx, "key3", []byte("value3")))
 
-	mockStorage.AssertCalled(t, "Delete", ctx, "key2")
+	mockStorage.AssertCalled(t, "Delete", mock.Anything, "key2")
 }
 
 func TestLRUCache_ErrorHandling(t *testing.T) {
 	ctx := context.Background()
 	mockStorage := new(MockStorage)
-	cache := NewLRUCache(1, mockStorage)
+	cache := NewLRUCache("test", 1, mockStorage)
 
 	storageError := errors.New("storage error")
-	mockStorage.On("Set", ctx, "key1", []byte("value1")).Return(nil)
-	mockStorage.On("Set", ctx, "key2", []byte("value2")).Return(nil)
-	mockStorage.On("Delete", ctx, "key1").Return(storageError)
+	mockStorage.On("Set", mock.Anything, "key1", []byte("value1")).Return(nil)
+	mockStorage.On("Set", mock.Anything, "key2", []byte("value2")).Return(nil)
+	mockStorage.On("Delete", mock.Anything, "key1").Return(storageError)
 	mockStorage.On("Size").Return(0)
 
 	assert.NoError(t, cache.Set(ctx, "key1", []byte("value1")))
@@ -108,12 +155,72 @@ func TestLRUCache_ErrorHandling(t *testing.T) {
 func TestLRUCache_EdgeCases(t *testing.T) {
 	ctx := context.Background()
 	mockStorage := new(MockStorage)
-	cache := NewLRUCache(0, mockStorage)
+	cache := NewLRUCache("test", 0, mockStorage)
 
-	mockStorage.On("Set", ctx, "key1", []byte("value1")).Return(nil)
-	mockStorage.On("Delete", ctx, "key1").Return(nil)
+	mockStorage.On("Set", mock.Anything, "key1", []byte("value1")).Return(nil)
+	mockStorage.On("Delete", mock.Anything, "key1").Return(nil)
 	mockStorage.On("Size").Return(0)
 
 	assert.NoError(t, cache.Set(ctx, "key1", []byte("value1")))
-	mockStorage.AssertCalled(t, "Delete", ctx, "key1")
+	mockStorage.AssertCalled(t, "Delete", mock.Anything, "key1")
+}
+
+func TestLRUCache_Metrics(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := new(MockStorage)
+	cache := NewLRUCache("metrics", 1, mockStorage)
+
+	mockStorage.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("Delete", mock.Anything, "key1").Return(nil)
+	mockStorage.On("Get", mock.Anything, "key2").Return([]byte("value2"), nil)
+	mockStorage.On("Get", mock.Anything, "key3").Return(nil, os.ErrNotExist)
+
+	assert.NoError(t, cache.Set(ctx, "key1", []byte("value1")))
+	assert.NoError(t, cache.Set(ctx, "key2", []byte("value2")))
+	_, err := cache.Get(ctx, "key2")
+	assert.NoError(t, err)
+	_, err = cache.Get(ctx, "key3")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `imageproxy_cache_hits_total{cache="metrics"} 1`)
+	assert.Contains(t, body, `imageproxy_cache_misses_total{cache="metrics"} 1`)
+	assert.Contains(t, body, `imageproxy_cache_evictions_total{cache="metrics"} 1`)
+}
+
+func TestLRUCache_Stream(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	cache := NewLRUCache("stream", 1, store)
+
+	w, err := cache.Create(ctx, "key1")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("value1"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Commit())
+
+	r, err := cache.Open(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), r.Size())
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", string(data))
+	assert.NoError(t, r.Close())
+
+	// Отмененная запись не попадает в кэш и не вытесняет key1
+	w, err = cache.Create(ctx, "key2")
+	assert.NoError(t, err)
+	w.Abort()
+	_, err = cache.Open(ctx, "key2")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Значение, сохраненное в хранилище до запуска, добавляется в кэш
+	assert.NoError(t, store.Set(ctx, "key3", []byte("value3")))
+	r, err = cache.Open(ctx, "key3")
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	_, err = cache.Get(ctx, "key1")
+	assert.ErrorIs(t, err, os.ErrNotExist, "key1 is evicted")
 }
@@ -0,0 +1,404 @@
+// Package config загружает конфигурацию сервера из файла YAML, переменных
+// окружения и флагов командной строки.
+//
+// Приоритет источников по возрастанию: значения по умолчанию, файл,
+// переменные окружения, флаги. Файл задается флагом -config или переменной
+// CONFIG_FILE.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"imageproxy/internal/logging"
+	"imageproxy/internal/processor"
+	"imageproxy/internal/tracing"
+	"imageproxy/pkg/urlsign"
+)
+
+// Типы хранилища кэша.
+const (
+	StorageFile   = "file"
+	StorageMemory = "memory"
+)
+
+// redacted заменяет секреты при выводе конфигурации.
+const redacted = "REDACTED"
+
+// minAdminTokenLength минимальная длина токена API администрирования.
+const minAdminTokenLength = 16
+
+// Config конфигурация сервера.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	Storage    Storage    `yaml:"storage"`
+	Cache      Cache      `yaml:"cache"`
+	Processing Processing `yaml:"processing"`
+	Limits     Limits     `yaml:"limits"`
+	Security   Security   `yaml:"security"`
+	Log        Log        `yaml:"log"`
+	Tracing    Tracing    `yaml:"tracing"`
+
+	// File путь к файлу, из которого загружена конфигурация.
+	File string `yaml:"-"`
+}
+
+// Server настройки HTTP-сервера.
+type Server struct {
+	Port int `yaml:"port"`
+	// PresetsOnly разрешает только пресеты без переопределений.
+	PresetsOnly bool `yaml:"presetsOnly"`
+	// ShutdownDelay время между началом остановки и закрытием соединений,
+	// пока /health отвечает 503.
+	ShutdownDelay   time.Duration `yaml:"shutdownDelay"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// ConfigWatchInterval период проверки изменения файла конфигурации,
+	// 0 - перечитывать только по SIGHUP.
+	ConfigWatchInterval time.Duration `yaml:"configWatchInterval"`
+	// DebugHeaders добавляет к ответам заголовки Server-Timing,
+	// Cache-Status и X-Original-Size.
+	DebugHeaders bool `yaml:"debugHeaders"`
+	// CacheControl значение Cache-Control ответов с изображениями,
+	// SignedCacheControl - ответов на подписанные URL. Пустое значение
+	// отключает заголовок.
+	CacheControl       string `yaml:"cacheControl"`
+	SignedCacheControl string `yaml:"signedCacheControl"`
+}
+
+// Storage настройки хранилища кэша.
+type Storage struct {
+	Type string `yaml:"type"`
+	Dir  string `yaml:"dir"`
+}
+
+// Cache размеры кэшей оригиналов и обработанных изображений.
+type Cache struct {
+	Capacity        int `yaml:"capacity"`
+	VariantCapacity int `yaml:"variantCapacity"`
+}
+
+// Processing настройки обработки, см. processor.Config.
+type Processing struct {
+	JPEGQuality              int      `yaml:"jpegQuality"`
+	JPEGQualityMin           int      `yaml:"jpegQualityMin"`
+	JPEGQualityMax           int      `yaml:"jpegQualityMax"`
+	PNGCompression           string   `yaml:"pngCompression"`
+	MaxBytesPolicy           string   `yaml:"maxBytesPolicy"`
+	ResampleFilter           string   `yaml:"resampleFilter"`
+	FallbackFilter           string   `yaml:"fallbackFilter"`
+	ExpensiveFilterMaxPixels int      `yaml:"expensiveFilterMaxPixels"`
+	AutoOrient               bool     `yaml:"autoOrient"`
+	KeepCopyrightOrigins     []string `yaml:"keepCopyrightOrigins"`
+	MetricsOrigins           []string `yaml:"metricsOrigins"`
+	MaxFrames                int      `yaml:"maxFrames"`
+	MaxOutputPixels          int      `yaml:"maxOutputPixels"`
+	// Presets конвейеры пресетов по именам.
+	Presets map[string]string `yaml:"presets"`
+}
+
+// Limits ограничения нагрузки.
+type Limits struct {
+	RateLimit           float64       `yaml:"rateLimit"`
+	RateBurst           int           `yaml:"rateBurst"`
+	MissRateLimit       float64       `yaml:"missRateLimit"`
+	MissRateBurst       int           `yaml:"missRateBurst"`
+	ClientConcurrency   int           `yaml:"clientConcurrency"`
+	RenderWorkers       int           `yaml:"renderWorkers"`
+	RenderQueueDepth    int           `yaml:"renderQueueDepth"`
+	RenderQueueTimeout  time.Duration `yaml:"renderQueueTimeout"`
+	MemoryBudgetMB      int           `yaml:"memoryBudgetMB"`
+	MemoryBudgetTimeout time.Duration `yaml:"memoryBudgetTimeout"`
+}
+
+// Security настройки подписи URL, ключей клиентов и доступа к API
+// администрирования.
+type Security struct {
+	// SigningKeys ключи подписи в шестнадцатеричной записи по идентификаторам.
+	SigningKeys map[string]string `yaml:"signingKeys"`
+	// APIKeys ключи API по именам клиентов. Запросы с известным ключом
+	// ограничиваются по имени клиента, а не по IP-адресу.
+	APIKeys map[string]string `yaml:"apiKeys"`
+	// AdminToken токен API администрирования, пустое значение отключает API.
+	AdminToken string `yaml:"adminToken"`
+}
+
+// Log настройки журнала.
+type Log struct {
+	// Format формат записей: text или json.
+	Format string `yaml:"format"`
+	// Level минимальный уровень записей: debug, info, warn или error.
+	Level string `yaml:"level"`
+}
+
+// Tracing настройки трассировки OpenTelemetry.
+type Tracing struct {
+	// Exporter куда отправляются трассы: none, otlp или stdout.
+	Exporter string `yaml:"exporter"`
+	// Endpoint URL приемника OTLP/HTTP, по умолчанию из переменных
+	// OTEL_EXPORTER_OTLP_* или http://localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio доля записываемых трасс для запросов без контекста
+	// трассировки.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Default возвращает конфигурацию по умолчанию.
+func Default() Config {
+	pc := processor.DefaultConfig()
+	return Config{
+		Server: Server{
+			Port:               8081,
+			ShutdownTimeout:    30 * time.Second,
+			CacheControl:       "public, max-age=86400",
+			SignedCacheControl: "public, max-age=31536000, immutable",
+		},
+		Storage: Storage{Type: StorageFile, Dir: "./image_cache"},
+		Cache:   Cache{Capacity: 5, VariantCapacity: 20},
+		Processing: Processing{
+			JPEGQuality:              pc.DefaultQuality,
+			JPEGQualityMin:           pc.MinQuality,
+			JPEGQualityMax:           pc.MaxQuality,
+			PNGCompression:           pc.PNGCompression,
+			MaxBytesPolicy:           pc.MaxBytesPolicy,
+			ResampleFilter:           pc.DefaultFilter,
+			FallbackFilter:           pc.FallbackFilter,
+			ExpensiveFilterMaxPixels: pc.ExpensiveFilterMaxPixels,
+			AutoOrient:               pc.AutoOrient,
+			MaxFrames:                pc.MaxFrames,
+			MaxOutputPixels:          pc.MaxOutputPixels,
+		},
+		Limits: Limits{
+			RateBurst:           20,
+			MissRateBurst:       5,
+			RenderWorkers:       pc.RenderWorkers,
+			RenderQueueDepth:    pc.RenderQueueDepth,
+			RenderQueueTimeout:  pc.RenderQueueTimeout,
+			MemoryBudgetMB:      int(pc.MemoryBudget >> 20),
+			MemoryBudgetTimeout: pc.MemoryBudgetTimeout,
+		},
+		Log:     Log{Format: logging.FormatText, Level: "info"},
+		Tracing: Tracing{Exporter: tracing.ExporterNone, SampleRatio: 1},
+	}
+}
+
+// Load собирает конфигурацию из файла, переменных окружения и флагов args.
+// Возвращает также, запрошен ли вывод конфигурации флагом -print-config.
+// Для -help возвращает flag.ErrHelp.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, bool, error) {
+	type flagValue struct {
+		field field
+		value string
+	}
+	var flagValues []flagValue
+
+	fs := flag.NewFlagSet("imageproxy", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to the YAML configuration file (env CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	for _, f := range fields {
+		fs.Func(f.key, fmt.Sprintf("%s (env %s)", f.usage, f.env), func(s string) error {
+			flagValues = append(flagValues, flagValue{field: f, value: s})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, false, fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	config := Default()
+	if *configFile == "" {
+		*configFile, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return Config{}, false, err
+		}
+		config.File = *configFile
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if value, ok := lookupEnv(f.env); ok && value != "" {
+			if err := f.set(&config, value); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", f.env, err))
+			}
+		}
+	}
+	for _, fv := range flagValues {
+		if err := fv.field.set(&config, fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", fv.field.key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return Config{}, false, errors.Join(errs...)
+	}
+
+	return config, *printConfig, config.Validate()
+}
+
+// loadFile накладывает на конфигурацию значения из файла. Неизвестные ключи
+// считаются ошибкой, чтобы опечатки не проходили незамеченными.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 1<<16, "server.port", "must be in 1..65535, got %d", c.Server.Port)
+	check(c.Server.ShutdownDelay >= 0, "server.shutdownDelay", "must not be negative, got %s", c.Server.ShutdownDelay)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout", "must be positive, got %s", c.Server.ShutdownTimeout)
+	check(c.Server.ConfigWatchInterval >= 0, "server.configWatchInterval", "must not be negative, got %s",
+		c.Server.ConfigWatchInterval)
+	check(slices.Contains([]string{StorageFile, StorageMemory}, c.Storage.Type),
+		"storage.type", "must be %q or %q, got %q", StorageFile, StorageMemory, c.Storage.Type)
+	check(c.Storage.Type != StorageFile || c.Storage.Dir != "", "storage.dir", "must be set for file storage")
+	check(c.Cache.Capacity > 0, "cache.capacity", "must be positive, got %d", c.Cache.Capacity)
+	check(c.Cache.VariantCapacity > 0, "cache.variantCapacity", "must be positive, got %d", c.Cache.VariantCapacity)
+	check(c.Limits.RateLimit >= 0, "limits.rateLimit", "must not be negative, got %g", c.Limits.RateLimit)
+	check(c.Limits.RateBurst > 0, "limits.rateBurst", "must be positive, got %d", c.Limits.RateBurst)
+	check(c.Limits.MissRateLimit >= 0, "limits.missRateLimit", "must not be negative, got %g", c.Limits.MissRateLimit)
+	check(c.Limits.MissRateBurst > 0, "limits.missRateBurst", "must be positive, got %d", c.Limits.MissRateBurst)
+	check(c.Limits.ClientConcurrency >= 0, "limits.clientConcurrency", "must not be negative, got %d",
+		c.Limits.ClientConcurrency)
+
+	check(c.Security.AdminToken == "" || len(c.Security.AdminToken) >= minAdminTokenLength, "security.adminToken",
+		"must be at least %d characters", minAdminTokenLength)
+	check(slices.Contains([]string{logging.FormatText, logging.FormatJSON}, c.Log.Format),
+		"log.format", "must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, c.Log.Format)
+	check(slices.Contains([]string{tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout}, c.Tracing.Exporter),
+		"tracing.exporter", "must be %q, %q or %q, got %q",
+		tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, c.Tracing.Exporter)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio",
+		"must be in 0..1, got %g", c.Tracing.SampleRatio)
+	if _, err := c.LogLevel(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if pc, err := c.ProcessorConfig(); err != nil {
+		errs = append(errs, err)
+	} else if err := pc.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("processing: %w", err))
+	}
+	if _, err := c.SigningKeys(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.APIClients(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ProcessorConfig возвращает настройки обработчика изображений.
+func (c Config) ProcessorConfig() (processor.Config, error) {
+	p, l := c.Processing, c.Limits
+	pc := processor.Config{
+		DefaultQuality:           p.JPEGQuality,
+		MinQuality:               p.JPEGQualityMin,
+		MaxQuality:               p.JPEGQualityMax,
+		PNGCompression:           p.PNGCompression,
+		MaxBytesPolicy:           p.MaxBytesPolicy,
+		DefaultFilter:            p.ResampleFilter,
+		ExpensiveFilterMaxPixels: p.ExpensiveFilterMaxPixels,
+		FallbackFilter:           p.FallbackFilter,
+		AutoOrient:               p.AutoOrient,
+		KeepCopyrightOrigins:     p.KeepCopyrightOrigins,
+		MetricsOrigins:           p.MetricsOrigins,
+		MaxFrames:                p.MaxFrames,
+		MaxOutputPixels:          p.MaxOutputPixels,
+		Presets:                  make(map[string]processor.Options, len(p.Presets)),
+		RenderWorkers:            l.RenderWorkers,
+		RenderQueueDepth:         l.RenderQueueDepth,
+		RenderQueueTimeout:       l.RenderQueueTimeout,
+		MemoryBudget:             int64(l.MemoryBudgetMB) << 20,
+		MemoryBudgetTimeout:      l.MemoryBudgetTimeout,
+	}
+	for name, pipeline := range p.Presets {
+		opts, err := processor.ParsePreset(name, pipeline)
+		if err != nil {
+			return pc, fmt.Errorf("processing.presets: %w", err)
+		}
+		pc.Presets[name] = opts
+	}
+	return pc, nil
+}
+
+// SigningKeys возвращает набор ключей подписи URL.
+func (c Config) SigningKeys() (urlsign.KeySet, error) {
+	keys := make(urlsign.KeySet, len(c.Security.SigningKeys))
+	for id, hexKey := range c.Security.SigningKeys {
+		key, err := urlsign.ParseKey(id, hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("security.signingKeys: %w", err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// APIClients возвращает имена клиентов по их ключам API.
+func (c Config) APIClients() (map[string]string, error) {
+	clients := make(map[string]string, len(c.Security.APIKeys))
+	for name, key := range c.Security.APIKeys {
+		if key == "" {
+			return nil, fmt.Errorf("security.apiKeys: key of client %q must not be empty", name)
+		}
+		if other, ok := clients[key]; ok {
+			return nil, fmt.Errorf("security.apiKeys: clients %q and %q have the same key", min(name, other), max(name, other))
+		}
+		clients[key] = name
+	}
+	return clients, nil
+}
+
+// LogLevel возвращает уровень журнала.
+func (c Config) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return level, fmt.Errorf("log.level: unknown level %q", c.Log.Level)
+	}
+	return level, nil
+}
+
+// YAML возвращает конфигурацию в формате файла без секретов.
+func (c Config) YAML() ([]byte, error) {
+	keys := make(map[string]string, len(c.Security.SigningKeys))
+	for id := range c.Security.SigningKeys {
+		keys[id] = redacted
+	}
+	c.Security.SigningKeys = keys
+	apiKeys := make(map[string]string, len(c.Security.APIKeys))
+	for name := range c.Security.APIKeys {
+		apiKeys[name] = redacted
+	}
+	c.Security.APIKeys = apiKeys
+	if c.Security.AdminToken != "" {
+		c.Security.AdminToken = redacted
+	}
+	return yaml.Marshal(c)
+}
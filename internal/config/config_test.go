@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env возвращает функцию поиска переменных окружения в vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	config, printConfig, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	assert.False(t, printConfig)
+	assert.Equal(t, Default(), config)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 9000
+  shutdownTimeout: 1m
+cache:
+  capacity: 10
+  variantCapacity: 30
+processing:
+  presets:
+    thumb: rs:fill:150:150/q:80
+`)
+	config, _, err := Load(
+		[]string{"-config", path, "-cache.variantCapacity=50", "--print-config"},
+		env(map[string]string{"CACHE_CAPACITY": "15", "VARIANT_CACHE_CAPACITY": "40", "STORAGE_TYPE": ""}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 9000, config.Server.Port)
+	assert.Equal(t, time.Minute, config.Server.ShutdownTimeout)
+	assert.Equal(t, 15, config.Cache.Capacity)
+	assert.Equal(t, 50, config.Cache.VariantCapacity)
+	// Пустая переменная окружения не меняет значение
+	assert.Equal(t, StorageFile, config.Storage.Type)
+
+	processorConfig, err := config.ProcessorConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "rs:fill:150:150/q:80", processorConfig.Presets["thumb"].String())
+
+	// Файл можно задать через переменную окружения
+	config, _, err = Load(nil, env(map[string]string{"CONFIG_FILE": path}))
+	require.NoError(t, err)
+	assert.Equal(t, 9000, config.Server.Port)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, _, err := Load(nil, env(map[string]string{"CACHE_CAPACITY": "many", "PORT": "0", "STORAGE_TYPE": "s3"}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `env CACHE_CAPACITY: invalid integer "many"`)
+
+	_, _, err = Load(nil, env(map[string]string{"CACHE_CAPACITY": "0", "PORT": "0", "STORAGE_TYPE": "s3"}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cache.capacity: must be positive, got 0")
+	assert.Contains(t, err.Error(), "server.port: must be in 1..65535, got 0")
+	assert.Contains(t, err.Error(), `storage.type: must be "file" or "memory", got "s3"`)
+
+	_, _, err = Load([]string{"-processing.jpegQuality=101"}, env(nil))
+	assert.ErrorContains(t, err, "processing: default quality 101 is out of bounds 1..100")
+
+	_, _, err = Load(nil, env(map[string]string{"PRESETS": "thumb=zoom:2"}))
+	assert.ErrorContains(t, err, `processing.presets: invalid preset "thumb"`)
+
+	_, _, err = Load(nil, env(map[string]string{"SIGNING_KEYS": "main:xyz"}))
+	assert.ErrorContains(t, err, `security.signingKeys: signing key "main" must be a non-empty hex string`)
+
+	_, _, err = Load(nil, env(map[string]string{"API_KEYS": "web:k1,app:k1"}))
+	assert.ErrorContains(t, err, `security.apiKeys: clients "app" and "web" have the same key`)
+	_, _, err = Load(nil, env(map[string]string{"API_KEYS": "web:"}))
+	assert.ErrorContains(t, err, `security.apiKeys: key of client "web" must not be empty`)
+
+	_, _, err = Load(nil, env(map[string]string{"ADMIN_TOKEN": "secret"}))
+	assert.ErrorContains(t, err, "security.adminToken: must be at least 16 characters")
+
+	_, _, err = Load(nil, env(map[string]string{"LOG_FORMAT": "xml", "LOG_LEVEL": "verbose"}))
+	assert.ErrorContains(t, err, `log.format: must be "text" or "json", got "xml"`)
+	assert.ErrorContains(t, err, `log.level: unknown level "verbose"`)
+
+	_, _, err = Load(nil, env(map[string]string{"TRACING_EXPORTER": "jaeger", "TRACING_SAMPLE_RATIO": "2"}))
+	assert.ErrorContains(t, err, `tracing.exporter: must be "none", "otlp" or "stdout", got "jaeger"`)
+	assert.ErrorContains(t, err, "tracing.sampleRatio: must be in 0..1, got 2")
+
+	_, _, err = Load([]string{"-config", writeConfig(t, "cache:\n  capasity: 10\n")}, env(nil))
+	assert.ErrorContains(t, err, "field capasity not found")
+
+	_, _, err = Load([]string{"-unknown"}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestConfig_YAML(t *testing.T) {
+	config, printConfig, err := Load([]string{"-print-config"}, env(map[string]string{
+		"SIGNING_KEYS": "main:0102",
+		"API_KEYS":     "web:webkey",
+		"ADMIN_TOKEN":  "0123456789abcdef",
+	}))
+	require.NoError(t, err)
+	assert.True(t, printConfig)
+
+	out, err := config.YAML()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "main: REDACTED")
+	assert.Contains(t, string(out), "web: REDACTED")
+	assert.Contains(t, string(out), "adminToken: REDACTED")
+	assert.Contains(t, string(out), "renderQueueTimeout: 5s")
+	assert.NotContains(t, string(out), "0102")
+	assert.NotContains(t, string(out), "webkey")
+	assert.NotContains(t, string(out), "0123456789abcdef")
+
+	// Выведенная конфигурация загружается обратно
+	config.Security.SigningKeys = nil
+	config.Security.APIKeys = nil
+	config.Security.AdminToken = ""
+	out, err = config.YAML()
+	require.NoError(t, err)
+	loaded, _, err := Load([]string{"-config", writeConfig(t, string(out))}, env(nil))
+	require.NoError(t, err)
+	again, err := loaded.YAML()
+	require.NoError(t, err)
+	assert.Equal(t, string(out), string(again))
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field настройка, которую можно задать переменной окружения env или флагом
+// -key. Ключ совпадает с путем настройки в файле.
+type field struct {
+	key   string
+	env   string
+	usage string
+	set   func(c *Config, s string) error
+}
+
+func newField[T any](key, env, usage string, ptr func(c *Config) *T, parse func(s string) (T, error)) field {
+	return field{
+		key:   key,
+		env:   env,
+		usage: usage,
+		set: func(c *Config, s string) error {
+			v, err := parse(s)
+			if err != nil {
+				return err
+			}
+			*ptr(c) = v
+			return nil
+		},
+	}
+}
+
+// fields настройки, доступные через переменные окружения и флаги.
+var fields = []field{
+	newField("server.port", "PORT", "HTTP port",
+		func(c *Config) *int { return &c.Server.Port }, parseInt),
+	newField("server.presetsOnly", "PRESETS_ONLY", "allow only presets without overrides",
+		func(c *Config) *bool { return &c.Server.PresetsOnly }, parseBool),
+	newField("server.shutdownDelay", "SHUTDOWN_DELAY", "delay before closing connections on shutdown",
+		func(c *Config) *time.Duration { return &c.Server.ShutdownDelay }, parseDuration),
+	newField("server.shutdownTimeout", "SHUTDOWN_TIMEOUT", "maximum time to drain requests on shutdown",
+		func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout }, parseDuration),
+	newField("server.configWatchInterval", "CONFIG_WATCH_INTERVAL", "config file check period, 0 to reload on SIGHUP only",
+		func(c *Config) *time.Duration { return &c.Server.ConfigWatchInterval }, parseDuration),
+	newField("server.debugHeaders", "DEBUG_HEADERS", "add Server-Timing, Cache-Status and X-Original-Size headers",
+		func(c *Config) *bool { return &c.Server.DebugHeaders }, parseBool),
+	newField("server.cacheControl", "CACHE_CONTROL", "Cache-Control of image responses, empty for none",
+		func(c *Config) *string { return &c.Server.CacheControl }, parseString),
+	newField("server.signedCacheControl", "SIGNED_CACHE_CONTROL", "Cache-Control of signed URL responses, empty for none",
+		func(c *Config) *string { return &c.Server.SignedCacheControl }, parseString),
+
+	newField("storage.type", "STORAGE_TYPE", "cache storage: file or memory",
+		func(c *Config) *string { return &c.Storage.Type }, parseString),
+	newField("storage.dir", "STORAGE_DIR", "file storage directory",
+		func(c *Config) *string { return &c.Storage.Dir }, parseString),
+
+	newField("cache.capacity", "CACHE_CAPACITY", "original images cache capacity",
+		func(c *Config) *int { return &c.Cache.Capacity }, parseInt),
+	newField("cache.variantCapacity", "VARIANT_CACHE_CAPACITY", "processed images cache capacity",
+		func(c *Config) *int { return &c.Cache.VariantCapacity }, parseInt),
+
+	newField("processing.jpegQuality", "JPEG_QUALITY", "default JPEG quality",
+		func(c *Config) *int { return &c.Processing.JPEGQuality }, parseInt),
+	newField("processing.jpegQualityMin", "JPEG_QUALITY_MIN", "minimum JPEG quality",
+		func(c *Config) *int { return &c.Processing.JPEGQualityMin }, parseInt),
+	newField("processing.jpegQualityMax", "JPEG_QUALITY_MAX", "maximum JPEG quality",
+		func(c *Config) *int { return &c.Processing.JPEGQualityMax }, parseInt),
+	newField("processing.pngCompression", "PNG_COMPRESSION", "PNG compression: default, none, speed or best",
+		func(c *Config) *string { return &c.Processing.PNGCompression }, parseString),
+	newField("processing.maxBytesPolicy", "MAXBYTES_POLICY", "maxbytes policy: fail or downscale",
+		func(c *Config) *string { return &c.Processing.MaxBytesPolicy }, parseString),
+	newField("processing.resampleFilter", "RESAMPLE_FILTER", "default resample filter",
+		func(c *Config) *string { return &c.Processing.ResampleFilter }, parseString),
+	newField("processing.fallbackFilter", "FALLBACK_FILTER", "filter replacing expensive ones for large images",
+		func(c *Config) *string { return &c.Processing.FallbackFilter }, parseString),
+	newField("processing.expensiveFilterMaxPixels", "EXPENSIVE_FILTER_MAX_PIXELS", "source area limit for expensive filters, 0 for none",
+		func(c *Config) *int { return &c.Processing.ExpensiveFilterMaxPixels }, parseInt),
+	newField("processing.autoOrient", "AUTO_ORIENT", "apply EXIF orientation",
+		func(c *Config) *bool { return &c.Processing.AutoOrient }, parseBool),
+	newField("processing.keepCopyrightOrigins", "KEEP_COPYRIGHT_ORIGINS", "comma-separated origins keeping EXIF Artist and Copyright",
+		func(c *Config) *[]string { return &c.Processing.KeepCopyrightOrigins }, parseList),
+	newField("processing.metricsOrigins", "METRICS_ORIGINS", "comma-separated origins with their own fetch metrics, others are \"other\"",
+		func(c *Config) *[]string { return &c.Processing.MetricsOrigins }, parseList),
+	newField("processing.maxFrames", "MAX_FRAMES", "maximum animation frames",
+		func(c *Config) *int { return &c.Processing.MaxFrames }, parseInt),
+	newField("processing.maxOutputPixels", "MAX_OUTPUT_PIXELS", "maximum pixels of output and intermediate images, 0 for none",
+		func(c *Config) *int { return &c.Processing.MaxOutputPixels }, parseInt),
+	newField("processing.presets", "PRESETS", "presets as name=pipeline separated by ';'",
+		func(c *Config) *map[string]string { return &c.Processing.Presets }, parseMap(";", "=")),
+
+	newField("limits.rateLimit", "RATE_LIMIT", "requests per second per client, 0 for none",
+		func(c *Config) *float64 { return &c.Limits.RateLimit }, parseFloat),
+	newField("limits.rateBurst", "RATE_BURST", "request burst per client",
+		func(c *Config) *int { return &c.Limits.RateBurst }, parseInt),
+	newField("limits.missRateLimit", "MISS_RATE_LIMIT", "cache misses per second per client, 0 for none",
+		func(c *Config) *float64 { return &c.Limits.MissRateLimit }, parseFloat),
+	newField("limits.missRateBurst", "MISS_RATE_BURST", "cache miss burst per client",
+		func(c *Config) *int { return &c.Limits.MissRateBurst }, parseInt),
+	newField("limits.clientConcurrency", "CLIENT_CONCURRENCY", "concurrent requests per client, 0 for none",
+		func(c *Config) *int { return &c.Limits.ClientConcurrency }, parseInt),
+	newField("limits.renderWorkers", "RENDER_WORKERS", "concurrent renders",
+		func(c *Config) *int { return &c.Limits.RenderWorkers }, parseInt),
+	newField("limits.renderQueueDepth", "RENDER_QUEUE_DEPTH", "render queue depth",
+		func(c *Config) *int { return &c.Limits.RenderQueueDepth }, parseInt),
+	newField("limits.renderQueueTimeout", "RENDER_QUEUE_TIMEOUT", "maximum render queue wait",
+		func(c *Config) *time.Duration { return &c.Limits.RenderQueueTimeout }, parseDuration),
+	newField("limits.memoryBudgetMB", "MEMORY_BUDGET_MB", "decoded images memory budget in MB, 0 for none",
+		func(c *Config) *int { return &c.Limits.MemoryBudgetMB }, parseInt),
+	newField("limits.memoryBudgetTimeout", "MEMORY_BUDGET_TIMEOUT", "maximum memory budget wait",
+		func(c *Config) *time.Duration { return &c.Limits.MemoryBudgetTimeout }, parseDuration),
+
+	newField("security.signingKeys", "SIGNING_KEYS", "URL signing keys as id:hexkey separated by ','",
+		func(c *Config) *map[string]string { return &c.Security.SigningKeys }, parseMap(",", ":")),
+	newField("security.apiKeys", "API_KEYS", "client API keys as name:key separated by ','",
+		func(c *Config) *map[string]string { return &c.Security.APIKeys }, parseMap(",", ":")),
+	newField("security.adminToken", "ADMIN_TOKEN", "admin API bearer token, empty disables the API",
+		func(c *Config) *string { return &c.Security.AdminToken }, parseString),
+
+	newField("log.format", "LOG_FORMAT", "log format: text or json",
+		func(c *Config) *string { return &c.Log.Format }, parseString),
+	newField("log.level", "LOG_LEVEL", "minimum log level: debug, info, warn or error",
+		func(c *Config) *string { return &c.Log.Level }, parseString),
+
+	newField("tracing.exporter", "TRACING_EXPORTER", "trace exporter: none, otlp or stdout",
+		func(c *Config) *string { return &c.Tracing.Exporter }, parseString),
+	newField("tracing.endpoint", "TRACING_ENDPOINT", "OTLP/HTTP collector URL",
+		func(c *Config) *string { return &c.Tracing.Endpoint }, parseString),
+	newField("tracing.sampleRatio", "TRACING_SAMPLE_RATIO", "share of sampled traces for requests without trace context",
+		func(c *Config) *float64 { return &c.Tracing.SampleRatio }, parseFloat),
+}
+
+func parseString(s string) (string, error) {
+	return s, nil
+}
+
+func parseInt(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+	return v, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+func parseBool(s string) (bool, error) {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", s)
+	}
+	return v, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return v, nil
+}
+
+func parseList(s string) ([]string, error) {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+// parseMap разбирает записи key<kvSep>value, разделенные entrySep.
+func parseMap(entrySep, kvSep string) func(s string) (map[string]string, error) {
+	return func(s string) (map[string]string, error) {
+		m := make(map[string]string)
+		for _, entry := range strings.Split(s, entrySep) {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(entry, kvSep)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid entry %q, expected key%svalue", entry, kvSep)
+			}
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf("duplicate key %q", key)
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+}
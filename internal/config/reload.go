@@ -0,0 +1,53 @@
+package config
+
+import "time"
+
+// staticField настройка, которая применяется только при запуске.
+type staticField struct {
+	key   string
+	equal func(a, b *Config) bool
+	copy  func(dst, src *Config)
+}
+
+func newStaticField[T comparable](key string, ptr func(c *Config) *T) staticField {
+	return staticField{
+		key:   key,
+		equal: func(a, b *Config) bool { return *ptr(a) == *ptr(b) },
+		copy:  func(dst, src *Config) { *ptr(dst) = *ptr(src) },
+	}
+}
+
+// staticFields настройки, для изменения которых нужен перезапуск: они
+// определяют слушающий порт, хранилище и размеры созданных при запуске
+// кэшей, пула и бюджета памяти, формат журнала и трассировку.
+var staticFields = []staticField{
+	newStaticField("server.port", func(c *Config) *int { return &c.Server.Port }),
+	newStaticField("server.configWatchInterval", func(c *Config) *time.Duration { return &c.Server.ConfigWatchInterval }),
+	newStaticField("storage.type", func(c *Config) *string { return &c.Storage.Type }),
+	newStaticField("storage.dir", func(c *Config) *string { return &c.Storage.Dir }),
+	newStaticField("cache.capacity", func(c *Config) *int { return &c.Cache.Capacity }),
+	newStaticField("cache.variantCapacity", func(c *Config) *int { return &c.Cache.VariantCapacity }),
+	newStaticField("limits.renderWorkers", func(c *Config) *int { return &c.Limits.RenderWorkers }),
+	newStaticField("limits.renderQueueDepth", func(c *Config) *int { return &c.Limits.RenderQueueDepth }),
+	newStaticField("limits.renderQueueTimeout", func(c *Config) *time.Duration { return &c.Limits.RenderQueueTimeout }),
+	newStaticField("limits.memoryBudgetMB", func(c *Config) *int { return &c.Limits.MemoryBudgetMB }),
+	newStaticField("limits.memoryBudgetTimeout", func(c *Config) *time.Duration { return &c.Limits.MemoryBudgetTimeout }),
+	newStaticField("log.format", func(c *Config) *string { return &c.Log.Format }),
+	newStaticField("tracing.exporter", func(c *Config) *string { return &c.Tracing.Exporter }),
+	newStaticField("tracing.endpoint", func(c *Config) *string { return &c.Tracing.Endpoint }),
+	newStaticField("tracing.sampleRatio", func(c *Config) *float64 { return &c.Tracing.SampleRatio }),
+}
+
+// Reloaded возвращает конфигурацию next, в которой настройки, не меняющиеся
+// без перезапуска, взяты из действующей конфигурации prev, и список таких
+// настроек, которые в next отличаются.
+func Reloaded(prev, next Config) (Config, []string) {
+	var ignored []string
+	for _, f := range staticFields {
+		if !f.equal(&prev, &next) {
+			ignored = append(ignored, f.key)
+			f.copy(&next, &prev)
+		}
+	}
+	return next, ignored
+}
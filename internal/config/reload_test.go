@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloaded(t *testing.T) {
+	prev := Default()
+	next := Default()
+	next.Server.Port = 9000
+	next.Cache.Capacity = 50
+	next.Limits.RateLimit = 10
+	next.Processing.Presets = map[string]string{"thumb": "rs:fill:150:150"}
+
+	reloaded, ignored := Reloaded(prev, next)
+	assert.Equal(t, []string{"server.port", "cache.capacity"}, ignored)
+	assert.Equal(t, prev.Server.Port, reloaded.Server.Port)
+	assert.Equal(t, prev.Cache.Capacity, reloaded.Cache.Capacity)
+	assert.Equal(t, 10.0, reloaded.Limits.RateLimit)
+	assert.Equal(t, next.Processing.Presets, reloaded.Processing.Presets)
+
+	_, ignored = Reloaded(prev, prev)
+	assert.Empty(t, ignored)
+}
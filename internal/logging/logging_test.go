@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "xml", slog.LevelInfo)
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatText, slog.LevelInfo)
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	assert.Empty(t, buf.String())
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.With("component", "test").InfoContext(ctx, "message")
+	assert.Contains(t, buf.String(), "msg=message")
+	assert.Contains(t, buf.String(), "component=test")
+	assert.Contains(t, buf.String(), "request_id=req-1")
+}
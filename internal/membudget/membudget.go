@@ -0,0 +1,168 @@
+// Package membudget ограничивает суммарный объем памяти, занятой
+// одновременно обрабатываемыми изображениями.
+package membudget
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"imageproxy/internal/metrics"
+)
+
+var (
+	// ErrExceedsBudget запрошенный объем больше всего бюджета.
+	ErrExceedsBudget = errors.New("image exceeds memory budget")
+	// ErrTimeout бюджет не освободился за время ожидания.
+	ErrTimeout = errors.New("memory budget timeout")
+)
+
+// Stats состояние бюджета.
+type Stats struct {
+	Size     int64  `json:"size"`
+	Used     int64  `json:"used"`
+	Waiting  int    `json:"waiting"`
+	Rejected uint64 `json:"rejected"`
+	TimedOut uint64 `json:"timedOut"`
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// Budget семафор, взвешенный объемом памяти. Ожидающие запросы
+// обслуживаются в порядке очереди, чтобы большие изображения не ждали
+// бесконечно за потоком маленьких. Nil Budget не ограничивает память.
+// Состояние бюджета публикуется в метриках memory_budget_*; в процессе
+// ожидается один бюджет.
+type Budget struct {
+	size    int64
+	timeout time.Duration
+
+	mu       sync.Mutex
+	used     int64
+	waiters  list.List
+	rejected uint64
+	timedOut uint64
+}
+
+// New создает бюджет в size байт, запросы которого ждут не дольше timeout.
+// При size <= 0 возвращает nil, и память не ограничивается.
+func New(size int64, timeout time.Duration) *Budget {
+	if size <= 0 {
+		return nil
+	}
+	metrics.MemoryBudget.Set(float64(size))
+	return &Budget{size: size, timeout: timeout}
+}
+
+// Acquire занимает n байт бюджета и возвращает функцию, освобождающую их.
+func (b *Budget) Acquire(ctx context.Context, n int64) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	release := func() { b.release(n) }
+
+	b.mu.Lock()
+	if n > b.size {
+		b.rejected++
+		b.mu.Unlock()
+		metrics.MemoryBudgetRejected.Inc()
+		return nil, fmt.Errorf("%w: %d bytes of %d", ErrExceedsBudget, n, b.size)
+	}
+	if b.size-b.used >= n && b.waiters.Len() == 0 {
+		b.used += n
+		b.observe()
+		b.mu.Unlock()
+		return release, nil
+	}
+	w := waiter{n: n, ready: make(chan struct{})}
+	elem := b.waiters.PushBack(w)
+	b.observe()
+	b.mu.Unlock()
+
+	start := time.Now()
+	defer func() { metrics.MemoryBudgetWait.Observe(time.Since(start).Seconds()) }()
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-timer.C:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	b.mu.Lock()
+	select {
+	case <-w.ready:
+		// Бюджет выделен одновременно с отменой, возвращаем его
+		b.mu.Unlock()
+		b.release(n)
+		return nil, err
+	default:
+	}
+	if errors.Is(err, ErrTimeout) {
+		b.timedOut++
+		metrics.MemoryBudgetTimeouts.Inc()
+	}
+	isFront := b.waiters.Front() == elem
+	b.waiters.Remove(elem)
+	// Ушедший первым в очереди мог задерживать следующих
+	if isFront {
+		b.notify()
+	}
+	b.observe()
+	b.mu.Unlock()
+	return nil, err
+}
+
+func (b *Budget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.notify()
+	b.observe()
+}
+
+// notify выделяет бюджет ожидающим в порядке очереди.
+func (b *Budget) notify() {
+	for elem := b.waiters.Front(); elem != nil; elem = b.waiters.Front() {
+		w := elem.Value.(waiter)
+		if b.size-b.used < w.n {
+			return
+		}
+		b.used += w.n
+		b.waiters.Remove(elem)
+		close(w.ready)
+	}
+}
+
+// observe публикует занятый объем и число ожидающих в метриках. Вызывается
+// под b.mu.
+func (b *Budget) observe() {
+	metrics.MemoryBudgetUsed.Set(float64(b.used))
+	metrics.MemoryBudgetWaiting.Set(float64(b.waiters.Len()))
+}
+
+// Stats возвращает текущее состояние бюджета.
+func (b *Budget) Stats() Stats {
+	if b == nil {
+		return Stats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stats{
+		Size:     b.size,
+		Used:     b.used,
+		Waiting:  b.waiters.Len(),
+		Rejected: b.rejected,
+		TimedOut: b.timedOut,
+	}
+}
@@ -0,0 +1,82 @@
+package membudget
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/metrics"
+)
+
+func TestBudget_Acquire(t *testing.T) {
+	ctx := context.Background()
+	b := New(100, time.Second)
+
+	releaseBig, err := b.Acquire(ctx, 80)
+	require.NoError(t, err)
+	_, err = b.Acquire(ctx, 101)
+	assert.ErrorIs(t, err, ErrExceedsBudget)
+
+	// Маленький запрос помещается в остаток, но ждет за большим
+	acquired := make(chan int64, 2)
+	for i, n := range []int64{50, 10} {
+		go func() {
+			release, err := b.Acquire(ctx, n)
+			if assert.NoError(t, err) {
+				acquired <- n
+				release()
+			}
+		}()
+		require.Eventually(t, func() bool { return b.Stats().Waiting == i+1 }, time.Second, time.Millisecond)
+	}
+	assert.Empty(t, acquired)
+
+	releaseBig()
+	assert.ElementsMatch(t, []int64{50, 10}, []int64{<-acquired, <-acquired})
+	require.Eventually(t, func() bool { return b.Stats().Used == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), b.Stats().Rejected)
+}
+
+func TestBudget_Timeout(t *testing.T) {
+	ctx := context.Background()
+	b := New(100, 10*time.Millisecond)
+
+	release, err := b.Acquire(ctx, 60)
+	require.NoError(t, err)
+	_, err = b.Acquire(ctx, 60)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = b.Acquire(canceled, 60)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	release()
+	assert.Equal(t, Stats{Size: 100, TimedOut: 1}, b.Stats())
+}
+
+func TestBudget_Disabled(t *testing.T) {
+	b := New(0, time.Second)
+	release, err := b.Acquire(context.Background(), 1<<40)
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, Stats{}, b.Stats())
+}
+
+func TestBudget_Metrics(t *testing.T) {
+	b := New(100, time.Second)
+	release, err := b.Acquire(context.Background(), 60)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, "imageproxy_memory_budget_bytes 100\n")
+	assert.Contains(t, body, "imageproxy_memory_budget_used_bytes 60\n")
+	assert.Contains(t, body, "imageproxy_memory_budget_waiting 0\n")
+	release()
+}
@@ -0,0 +1,192 @@
+// Package metrics содержит метрики сервиса в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "imageproxy"
+
+// Стадии обработки изображения для StageDuration. StageResize включает
+// все операции конвейера.
+const (
+	StageDecode = "decode"
+	StageResize = "resize"
+	StageEncode = "encode"
+)
+
+// Registry реестр метрик сервиса, включая метрики среды выполнения Go
+// и процесса.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// RequestsTotal число обработанных запросов по операции и статусу ответа.
+	RequestsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by operation and status.",
+	}, []string{"operation", "status"})
+	// RequestDuration время обработки запросов.
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by operation and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+	// ResponseBytes объем отданных ответов.
+	ResponseBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Bytes served by operation.",
+	}, []string{"operation"})
+
+	// CacheHits, CacheMisses и CacheEvictions события кэшей оригиналов
+	// и вариантов.
+	CacheHits = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Cache hits by cache.",
+	}, []string{"cache"})
+	CacheMisses = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Cache misses by cache.",
+	}, []string{"cache"})
+	CacheEvictions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Cache evictions by cache.",
+	}, []string{"cache"})
+
+	// OriginFetchDuration время загрузки изображений с источника.
+	OriginFetchDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "origin_fetch_duration_seconds",
+		Help:      "Origin fetch latency by host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+	// OriginFetchErrors число неудачных загрузок с источника.
+	OriginFetchErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "origin_fetch_errors_total",
+		Help:      "Failed origin fetches by host.",
+	}, []string{"host"})
+	// OriginBytes объем загруженных с источников изображений.
+	OriginBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "origin_bytes_total",
+		Help:      "Bytes fetched from origins.",
+	})
+
+	// StageDuration время стадий обработки изображения.
+	StageDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_stage_duration_seconds",
+		Help:      "Image processing stage latency by stage.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"stage"})
+
+	// RenderWorkers и RenderQueueDepth размеры пула обработки,
+	// RenderWorkersBusy и RenderQueueLength - его текущая загрузка.
+	RenderWorkers = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "render_workers",
+		Help:      "Render pool workers.",
+	})
+	RenderWorkersBusy = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "render_workers_busy",
+		Help:      "Render pool workers running a task.",
+	})
+	RenderQueueDepth = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "render_queue_depth",
+		Help:      "Maximum render queue length.",
+	})
+	RenderQueueLength = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "render_queue_length",
+		Help:      "Tasks waiting for a render worker.",
+	})
+	// RenderQueueWait время ожидания исполнителя задачами из очереди.
+	RenderQueueWait = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "render_queue_wait_seconds",
+		Help:      "Render queue wait time.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	// RenderQueueRejected и RenderQueueTimeouts задачи, отклоненные из-за
+	// заполненной очереди и не дождавшиеся исполнителя.
+	RenderQueueRejected = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "render_queue_rejected_total",
+		Help:      "Tasks rejected because the render queue was full.",
+	})
+	RenderQueueTimeouts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "render_queue_timeouts_total",
+		Help:      "Tasks that timed out waiting for a render worker.",
+	})
+
+	// MemoryBudget размер бюджета памяти, MemoryBudgetUsed и
+	// MemoryBudgetWaiting - занятый объем и число ожидающих обработок.
+	MemoryBudget = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_bytes",
+		Help:      "Memory budget size.",
+	})
+	MemoryBudgetUsed = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_used_bytes",
+		Help:      "Memory budget reserved by running renders.",
+	})
+	MemoryBudgetWaiting = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_waiting",
+		Help:      "Renders waiting for memory budget.",
+	})
+	// MemoryBudgetWait время ожидания бюджета памяти.
+	MemoryBudgetWait = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_wait_seconds",
+		Help:      "Memory budget wait time.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	// MemoryBudgetRejected и MemoryBudgetTimeouts обработки, которые
+	// больше всего бюджета и которые не дождались бюджета.
+	MemoryBudgetRejected = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_rejected_total",
+		Help:      "Renders rejected because they exceed the whole memory budget.",
+	})
+	MemoryBudgetTimeouts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memory_budget_timeouts_total",
+		Help:      "Renders that timed out waiting for memory budget.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveStage записывает время стадии обработки, начавшейся в start.
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Handler отдает метрики реестра Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Границы параметров коррекции. Радиус размытия и резкости ограничен,
+// так как время обработки растет вместе с ним.
+const (
+	maxSigma      = 50.0
+	maxPercentage = 100.0
+	minGamma      = 0.1
+	maxGamma      = 10.0
+)
+
+func checkRange(name string, value, low, high float64) error {
+	if value < low || value > high {
+		return fmt.Errorf("%w: %s %g is out of bounds %g..%g", ErrInvalidOptions, name, value, low, high)
+	}
+	return nil
+}
+
+// Brightness изменение яркости в процентах.
+type Brightness float64
+
+func (b Brightness) Name() string   { return "br" }
+func (b Brightness) Args() []string { return []string{formatFloat(float64(b))} }
+func (b Brightness) validate() error {
+	return checkRange("brightness", float64(b), -maxPercentage, maxPercentage)
+}
+
+func (b Brightness) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.AdjustBrightness(img, float64(b))
+}
+
+// Contrast изменение контраста в процентах.
+type Contrast float64
+
+func (c Contrast) Name() string   { return "co" }
+func (c Contrast) Args() []string { return []string{formatFloat(float64(c))} }
+func (c Contrast) validate() error {
+	return checkRange("contrast", float64(c), -maxPercentage, maxPercentage)
+}
+
+func (c Contrast) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.AdjustContrast(img, float64(c))
+}
+
+// Gamma гамма-коррекция, 1 - без изменений.
+type Gamma float64
+
+func (g Gamma) Name() string    { return "ga" }
+func (g Gamma) Args() []string  { return []string{formatFloat(float64(g))} }
+func (g Gamma) validate() error { return checkRange("gamma", float64(g), minGamma, maxGamma) }
+
+func (g Gamma) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.AdjustGamma(img, float64(g))
+}
+
+// Saturation изменение насыщенности в процентах.
+type Saturation float64
+
+func (s Saturation) Name() string   { return "sa" }
+func (s Saturation) Args() []string { return []string{formatFloat(float64(s))} }
+func (s Saturation) validate() error {
+	return checkRange("saturation", float64(s), -maxPercentage, maxPercentage)
+}
+
+func (s Saturation) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.AdjustSaturation(img, float64(s))
+}
+
+// Grayscale перевод в оттенки серого.
+type Grayscale struct{}
+
+func (Grayscale) Name() string                                  { return "gs" }
+func (Grayscale) Args() []string                                { return nil }
+func (Grayscale) validate() error                               { return nil }
+func (Grayscale) apply(img image.Image, _ *stepEnv) image.Image { return imaging.Grayscale(img) }
+
+// Invert негатив.
+type Invert struct{}
+
+func (Invert) Name() string                                  { return "inv" }
+func (Invert) Args() []string                                { return nil }
+func (Invert) validate() error                               { return nil }
+func (Invert) apply(img image.Image, _ *stepEnv) image.Image { return imaging.Invert(img) }
+
+// Blur гауссово размытие с заданной sigma.
+type Blur float64
+
+func (b Blur) Name() string    { return "bl" }
+func (b Blur) Args() []string  { return []string{formatFloat(float64(b))} }
+func (b Blur) validate() error { return checkRange("blur", float64(b), 0, maxSigma) }
+
+func (b Blur) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.Blur(img, float64(b))
+}
+
+// Sharpen повышение резкости с заданной sigma.
+type Sharpen float64
+
+func (s Sharpen) Name() string    { return "sh" }
+func (s Sharpen) Args() []string  { return []string{formatFloat(float64(s))} }
+func (s Sharpen) validate() error { return checkRange("sharpen", float64(s), 0, maxSigma) }
+
+func (s Sharpen) apply(img image.Image, _ *stepEnv) image.Image {
+	return imaging.Sharpen(img, float64(s))
+}
@@ -0,0 +1,195 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+
+	"github.com/disintegration/imaging"
+)
+
+// decodeAnimation возвращает GIF со всеми кадрами, если исходник - анимация
+// и результат тоже должен быть анимацией. Иначе возвращает nil.
+func decodeAnimation(data []byte, opts Options) *gif.GIF {
+	if opts.Format != "" && opts.Format != FormatGIF || opts.MaxFrames == 1 {
+		return nil
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "gif" {
+		return nil
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) < 2 {
+		return nil
+	}
+	return g
+}
+
+// composeFrames собирает полные кадры анимации с учетом смещений кадров и
+// способа их удаления (disposal). Возвращается не больше maxFrames кадров.
+func composeFrames(g *gif.GIF, maxFrames int) []*image.RGBA {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	count := min(len(g.Image), maxFrames)
+	frames := make([]*image.RGBA, 0, count)
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range g.Image[:count] {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// renderAnimation обрабатывает каждый кадр и кодирует результат в GIF
+// с исходными задержками и числом повторов.
+func (p *ImageProcessor) renderAnimation(ctx context.Context, g *gif.GIF, opts Options) (*Result, error) {
+	end := startStage(ctx, stageTransform)
+	frames := composeFrames(g, opts.MaxFrames)
+	env := p.newStepEnv(frames[0], opts)
+
+	transformed := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		transformed[i] = transform(frame, opts, env)
+	}
+	end()
+
+	// Уменьшение кадров под maxbytes входит во время кодирования
+	end = startStage(ctx, stageEncode)
+	defer end()
+
+	for {
+		out := &gif.GIF{LoopCount: g.LoopCount}
+		for i, frame := range transformed {
+			out.Image = append(out.Image, toPaletted(frame, framePalette(g.Image[i].Palette, opts.Operations)))
+			out.Delay = append(out.Delay, g.Delay[i])
+			// Кадры полные, поэтому перед следующим кадром область очищается
+			out.Disposal = append(out.Disposal, gif.DisposalBackground)
+		}
+
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, out); err != nil {
+			return nil, fmt.Errorf("failed to encode animation: %w", err)
+		}
+		if opts.MaxBytes == 0 || buf.Len() <= opts.MaxBytes {
+			return &Result{
+				Data:           buf.Bytes(),
+				ContentType:    "image/gif",
+				OriginalWidth:  g.Config.Width,
+				OriginalHeight: g.Config.Height,
+			}, nil
+		}
+
+		b := transformed[0].Bounds()
+		width, height := int(float64(b.Dx())*downscaleStep), int(float64(b.Dy())*downscaleStep)
+		if p.currentConfig().MaxBytesPolicy != MaxBytesDownscale || width < 1 || height < 1 {
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, opts.MaxBytes)
+		}
+		for i, frame := range transformed {
+			transformed[i] = imaging.Resize(frame, width, height, env.filter)
+		}
+	}
+}
+
+// framePalette возвращает палитру обработанного кадра: палитру исходного
+// кадра, к которой применены цветовые операции конвейера. Они меняют каждый
+// пиксель независимо от соседних, поэтому цвета кадра после них совпадают
+// с цветами измененной палитры.
+func framePalette(palette color.Palette, ops []Operation) color.Palette {
+	var adjusted image.Image
+	for _, op := range ops {
+		if !adjustsColors(op) {
+			continue
+		}
+		if adjusted == nil {
+			img := image.NewNRGBA(image.Rect(0, 0, len(palette), 1))
+			for i, c := range palette {
+				img.Set(i, 0, c)
+			}
+			adjusted = img
+		}
+		adjusted = op.apply(adjusted, nil)
+	}
+	if adjusted == nil {
+		return palette
+	}
+
+	result := make(color.Palette, len(palette))
+	b := adjusted.Bounds()
+	for i := range result {
+		result[i] = adjusted.At(b.Min.X+i, b.Min.Y)
+	}
+	return result
+}
+
+// adjustsColors сообщает, что операция меняет цвета пикселей.
+func adjustsColors(op Operation) bool {
+	switch op.(type) {
+	case Brightness, Contrast, Gamma, Saturation, Grayscale, Invert:
+		return true
+	}
+	return false
+}
+
+// toPaletted переводит кадр в палитру palette. Дизеринг не
+// используется, чтобы не было мерцания между кадрами.
+func toPaletted(img image.Image, palette color.Palette) *image.Paletted {
+	b := img.Bounds()
+	palette = append(color.Palette{}, palette...)
+	if len(palette) < 256 && hasTransparentPixels(img) && !hasTransparentColor(palette) {
+		palette = append(palette, color.Transparent)
+	}
+	paletted := image.NewPaletted(b, palette)
+	draw.Draw(paletted, b, img, b.Min, draw.Src)
+	return paletted
+}
+
+func hasTransparentPixels(img image.Image) bool {
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = imaging.Clone(img)
+	}
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i] == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTransparentColor(palette color.Palette) bool {
+	for _, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPalette = color.Palette{color.Transparent, color.White, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+
+// animatedGIF возвращает анимацию 40x40: белый фон, затем красный квадрат
+// со смещением (disposal background) и синий квадрат в другом углу.
+func animatedGIF(t *testing.T) []byte {
+	t.Helper()
+	background := image.NewPaletted(image.Rect(0, 0, 40, 40), testPalette)
+	for i := range background.Pix {
+		background.Pix[i] = 1
+	}
+	red := image.NewPaletted(image.Rect(20, 20, 40, 40), testPalette)
+	for i := range red.Pix {
+		red.Pix[i] = 2
+	}
+	blue := image.NewPaletted(image.Rect(0, 0, 20, 20), testPalette)
+	for i := range blue.Pix {
+		blue.Pix[i] = 3
+	}
+
+	g := &gif.GIF{
+		Image:     []*image.Paletted{background, red, blue},
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{Width: 40, Height: 40, ColorModel: testPalette},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return buf.Bytes()
+}
+
+func TestComposeFrames(t *testing.T) {
+	g, err := gif.DecodeAll(bytes.NewReader(animatedGIF(t)))
+	require.NoError(t, err)
+
+	frames := composeFrames(g, 10)
+	require.Len(t, frames, 3)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), frames[1].Bounds())
+	// Второй кадр наложен на первый со смещением
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, frames[1].RGBAAt(30, 30))
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, frames[1].RGBAAt(5, 5))
+	// Область второго кадра очищена перед третьим
+	assert.Equal(t, color.RGBA{}, frames[2].RGBAAt(30, 30))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, frames[2].RGBAAt(5, 5))
+
+	assert.Len(t, composeFrames(g, 2), 2)
+}
+
+func TestProcessImage_Animation(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOriginData(t, animatedGIF(t))
+	p := newTestProcessor(DefaultConfig())
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(20, 20), Filter: "nearest"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/gif", result.ContentType)
+
+	g, err := gif.DecodeAll(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	require.Len(t, g.Image, 3)
+	assert.Equal(t, []int{10, 20, 30}, g.Delay)
+	assert.Equal(t, 3, g.LoopCount)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), g.Image[2].Bounds())
+	_, _, _, a := g.Image[2].At(15, 15).RGBA()
+	assert.Zero(t, a)
+
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(20, 20), MaxFrames: 2})
+	require.NoError(t, err)
+	g, err = gif.DecodeAll(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Len(t, g.Image, 2)
+
+	// Только первый кадр - статичное изображение
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(20, 20), MaxFrames: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", result.ContentType)
+	_, err = jpeg.Decode(bytes.NewReader(result.Data))
+	assert.NoError(t, err)
+}
+
+func TestProcessImage_AnimationColors(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOriginData(t, animatedGIF(t))
+	p := newTestProcessor(DefaultConfig())
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: []Operation{Grayscale{}}})
+	require.NoError(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	require.Len(t, g.Image, 3)
+
+	// Цвета кадров берутся из палитры, измененной так же, как кадры
+	for i, frame := range g.Image {
+		for _, c := range frame.Palette {
+			r, g, b, _ := c.RGBA()
+			assert.True(t, r == g && g == b, "frame %d has color %v", i, c)
+		}
+	}
+	red := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	red.Set(0, 0, color.RGBA{R: 255, A: 255})
+	want := imaging.Grayscale(red).At(0, 0)
+	assert.Equal(t, want, color.NRGBAModel.Convert(g.Image[1].At(30, 30)))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBAModel.Convert(g.Image[1].At(5, 5)))
+}
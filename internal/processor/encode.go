@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrTooLarge возвращается, если результат не укладывается в maxbytes.
+var ErrTooLarge = errors.New("image does not fit into byte budget")
+
+// Политики на случай, когда изображение не укладывается в maxbytes
+// даже при минимальном качестве.
+const (
+	MaxBytesFail      = "fail"
+	MaxBytesDownscale = "downscale"
+)
+
+// downscaleStep коэффициент уменьшения размеров при политике downscale.
+const downscaleStep = 0.8
+
+// encode кодирует изображение согласно нормализованным параметрам.
+func encode(w io.Writer, img image.Image, opts Options) error {
+	switch opts.Format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: pngCompressionLevels[opts.PNGCompression]}
+		return enc.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	case FormatJPEG:
+		if opts.Progressive {
+			return encodeProgressiveJPEG(w, img, opts.Quality)
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+	}
+	return fmt.Errorf("unsupported format: %s", opts.Format)
+}
+
+// encodeWithinBudget кодирует изображение так, чтобы результат не превышал
+// opts.MaxBytes. Для JPEG качество подбирается бинарным поиском между
+// минимальным качеством и запрошенным. Возвращает данные и итоговое качество.
+func (p *ImageProcessor) encodeWithinBudget(
+	img image.Image, opts Options, filter imaging.ResampleFilter,
+) ([]byte, int, error) {
+	for {
+		data, quality, err := p.encodeBestFit(img, opts)
+		if err != nil || data != nil {
+			return data, quality, err
+		}
+
+		if p.currentConfig().MaxBytesPolicy != MaxBytesDownscale {
+			return nil, 0, fmt.Errorf("%w: %d bytes", ErrTooLarge, opts.MaxBytes)
+		}
+
+		b := img.Bounds()
+		width, height := int(float64(b.Dx())*downscaleStep), int(float64(b.Dy())*downscaleStep)
+		if width < 1 || height < 1 {
+			return nil, 0, fmt.Errorf("%w: %d bytes", ErrTooLarge, opts.MaxBytes)
+		}
+		img = imaging.Resize(img, width, height, filter)
+	}
+}
+
+// encodeBestFit возвращает nil без ошибки, если изображение не помещается
+// в бюджет ни при каком допустимом качестве.
+func (p *ImageProcessor) encodeBestFit(img image.Image, opts Options) ([]byte, int, error) {
+	if opts.Format != FormatJPEG {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, opts); err != nil {
+			return nil, 0, err
+		}
+		if buf.Len() > opts.MaxBytes {
+			return nil, 0, nil
+		}
+		return buf.Bytes(), 0, nil
+	}
+
+	var best []byte
+	bestQuality := 0
+	low, high := p.currentConfig().MinQuality, opts.Quality
+	for low <= high {
+		opts.Quality = (low + high) / 2
+		var buf bytes.Buffer
+		if err := encode(&buf, img, opts); err != nil {
+			return nil, 0, err
+		}
+		if buf.Len() <= opts.MaxBytes {
+			best, bestQuality = buf.Bytes(), opts.Quality
+			low = opts.Quality + 1
+		} else {
+			high = opts.Quality - 1
+		}
+	}
+	return best, bestQuality, nil
+}
@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Теги EXIF, которые нас интересуют.
+const (
+	exifTagOrientation = 0x0112
+	exifTagArtist      = 0x013b
+	exifTagCopyright   = 0x8298
+)
+
+// exifData поля EXIF исходного изображения, используемые при обработке.
+type exifData struct {
+	Orientation int
+	Artist      string
+	Copyright   string
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// parseJPEGExif извлекает поля EXIF из JPEG. Разбор выполняется
+// по возможности: при любой ошибке возвращаются уже найденные значения.
+func parseJPEGExif(data []byte) exifData {
+	var ex exifData
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return ex
+	}
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return ex
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		// SOS и EOI: дальше идут данные изображения, метаданных там нет
+		if marker == 0xda || marker == 0xd9 {
+			return ex
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return ex
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, exifHeader) {
+			return parseTIFF(segment[len(exifHeader):])
+		}
+		pos = end
+	}
+	return ex
+}
+
+// parseTIFF разбирает IFD0 из TIFF-заголовка EXIF.
+func parseTIFF(tiff []byte) exifData {
+	var ex exifData
+	if len(tiff) < 8 {
+		return ex
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return ex
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return ex
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return ex
+		}
+		tag := order.Uint16(tiff[entry:])
+		typ := order.Uint16(tiff[entry+2:])
+		n := int(order.Uint32(tiff[entry+4:]))
+		switch {
+		case tag == exifTagOrientation && typ == 3:
+			ex.Orientation = int(order.Uint16(tiff[entry+8:]))
+		case (tag == exifTagArtist || tag == exifTagCopyright) && typ == 2:
+			value := tiff[entry+8 : entry+12]
+			if n > 4 {
+				start := int(order.Uint32(tiff[entry+8:]))
+				if start < 0 || n < 0 || start+n > len(tiff) {
+					continue
+				}
+				value = tiff[start : start+n]
+			} else {
+				value = value[:n]
+			}
+			text := string(bytes.TrimRight(value, "\x00"))
+			if tag == exifTagArtist {
+				ex.Artist = text
+			} else {
+				ex.Copyright = text
+			}
+		}
+	}
+	return ex
+}
+
+// applyOrientation поворачивает изображение согласно тегу EXIF Orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// copyrightMetadata возвращает блок с полями Artist и Copyright для вставки
+// в закодированное изображение и смещение, по которому его нужно вставить.
+func copyrightMetadata(format Format, ex exifData) ([]byte, int) {
+	if ex.Artist == "" && ex.Copyright == "" {
+		return nil, 0
+	}
+	switch format {
+	case FormatJPEG:
+		// Сразу после SOI
+		return copyrightExifSegment(ex), 2
+	case FormatPNG:
+		var chunks []byte
+		if ex.Artist != "" {
+			chunks = append(chunks, pngTextChunk("Author", ex.Artist)...)
+		}
+		if ex.Copyright != "" {
+			chunks = append(chunks, pngTextChunk("Copyright", ex.Copyright)...)
+		}
+		// После сигнатуры (8 байт) и чанка IHDR (25 байт)
+		return chunks, 33
+	}
+	return nil, 0
+}
+
+// withCopyright добавляет в закодированное изображение поля Artist и
+// Copyright. Остальные метаданные в результат не попадают: стандартные
+// кодировщики их не пишут.
+func withCopyright(data []byte, format Format, ex exifData) []byte {
+	block, offset := copyrightMetadata(format, ex)
+	if len(block) == 0 || len(data) < offset {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(block))
+	out = append(out, data[:offset]...)
+	out = append(out, block...)
+	return append(out, data[offset:]...)
+}
+
+// copyrightExifSegment собирает сегмент APP1 с IFD0 из тегов Artist и Copyright.
+func copyrightExifSegment(ex exifData) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	var entries []entry
+	if ex.Artist != "" {
+		entries = append(entries, entry{exifTagArtist, ex.Artist})
+	}
+	if ex.Copyright != "" {
+		entries = append(entries, entry{exifTagCopyright, ex.Copyright})
+	}
+
+	order := binary.BigEndian
+	ifdSize := 2 + 12*len(entries) + 4
+	tiff := make([]byte, 8+ifdSize)
+	copy(tiff, "MM\x00\x2a")
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], uint16(len(entries)))
+	for i, e := range entries {
+		value := append([]byte(e.value), 0)
+		pos := 10 + i*12
+		order.PutUint16(tiff[pos:], e.tag)
+		order.PutUint16(tiff[pos+2:], 2)
+		order.PutUint32(tiff[pos+4:], uint32(len(value)))
+		if len(value) <= 4 {
+			copy(tiff[pos+8:], value)
+			continue
+		}
+		order.PutUint32(tiff[pos+8:], uint32(len(tiff)))
+		tiff = append(tiff, value...)
+	}
+
+	segment := []byte{0xff, 0xe1, 0, 0}
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+func pngTextChunk(keyword, text string) []byte {
+	payload := append([]byte(keyword), 0)
+	payload = append(payload, text...)
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
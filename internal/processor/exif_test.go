@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegWithExif возвращает JPEG 64x32 с сегментом EXIF (little-endian),
+// содержащим Orientation и Copyright.
+func jpegWithExif(t *testing.T, orientation uint16, copyright string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(64, 32), nil))
+
+	order := binary.LittleEndian
+	value := append([]byte(copyright), 0)
+	tiff := make([]byte, 8+2+2*12+4)
+	copy(tiff, "II\x2a\x00")
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+	order.PutUint16(tiff[10:], exifTagOrientation)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+	order.PutUint16(tiff[22:], exifTagCopyright)
+	order.PutUint16(tiff[24:], 2)
+	order.PutUint32(tiff[26:], uint32(len(value)))
+	order.PutUint32(tiff[30:], uint32(len(tiff)))
+	tiff = append(tiff, value...)
+
+	segment := []byte{0xff, 0xe1, 0, 0}
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestParseJPEGExif(t *testing.T) {
+	ex := parseJPEGExif(jpegWithExif(t, 6, "ACME Photo 2026"))
+	assert.Equal(t, 6, ex.Orientation)
+	assert.Equal(t, "ACME Photo 2026", ex.Copyright)
+	assert.Empty(t, ex.Artist)
+
+	assert.Equal(t, exifData{}, parseJPEGExif([]byte("not a jpeg")))
+}
+
+func TestProcessImage_AutoOrient(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOriginData(t, jpegWithExif(t, 6, "ACME"))
+	p := newTestProcessor(DefaultConfig())
+
+	// Ширина 0 сохраняет пропорции: после поворота изображение вертикальное
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(0, 64)})
+	require.NoError(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 32, img.Bounds().Dx())
+
+	off := false
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(0, 64), AutoOrient: &off})
+	require.NoError(t, err)
+	img, err = jpeg.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 128, img.Bounds().Dx())
+}
+
+func TestProcessImage_StripMetadata(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOriginData(t, jpegWithExif(t, 1, "ACME Photo 2026"))
+
+	p := newTestProcessor(DefaultConfig())
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 16)})
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(result.Data, exifHeader))
+
+	config := DefaultConfig()
+	host, _, _ := bytes.Cut([]byte(url), []byte("/"))
+	config.KeepCopyrightOrigins = []string{string(host)}
+	p = newTestProcessor(config)
+	for _, format := range []Format{FormatJPEG, FormatPNG} {
+		result, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 16), Format: format})
+		require.NoError(t, err)
+		assert.True(t, bytes.Contains(result.Data, []byte("ACME Photo 2026")), format)
+		_, _, err = image.Decode(bytes.NewReader(result.Data))
+		assert.NoError(t, err, format)
+	}
+	assert.Equal(t, "ACME Photo 2026", parseJPEGExif(mustProcess(t, p, url, Options{Operations: resize(32, 16)})).Copyright)
+}
+
+func mustProcess(t *testing.T, p *ImageProcessor, url string, opts Options) []byte {
+	t.Helper()
+	result, err := p.ProcessImage(context.Background(), url, opts)
+	require.NoError(t, err)
+	return result.Data
+}
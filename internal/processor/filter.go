@@ -0,0 +1,43 @@
+package processor
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// resampleFilters фильтры масштабирования, доступные в запросах.
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"hermite":    imaging.Hermite,
+	"mitchell":   imaging.MitchellNetravali,
+	"catmullrom": imaging.CatmullRom,
+	"bspline":    imaging.BSpline,
+	"gaussian":   imaging.Gaussian,
+	"bartlett":   imaging.Bartlett,
+	"lanczos":    imaging.Lanczos,
+	"hann":       imaging.Hann,
+	"hamming":    imaging.Hamming,
+	"blackman":   imaging.Blackman,
+	"welch":      imaging.Welch,
+	"cosine":     imaging.Cosine,
+}
+
+// expensiveFilterSupport фильтры с большим радиусом считаются дорогими.
+const expensiveFilterSupport = 2.0
+
+// resampleFilter возвращает фильтр для масштабирования img. Дорогие фильтры
+// заменяются на FallbackFilter, если исходное изображение больше порога.
+func (p *ImageProcessor) resampleFilter(img image.Image, name string) imaging.ResampleFilter {
+	filter := resampleFilters[name]
+	if p.currentConfig().ExpensiveFilterMaxPixels <= 0 || filter.Support <= expensiveFilterSupport {
+		return filter
+	}
+	b := img.Bounds()
+	if b.Dx()*b.Dy() > p.currentConfig().ExpensiveFilterMaxPixels {
+		return resampleFilters[p.currentConfig().FallbackFilter]
+	}
+	return filter
+}
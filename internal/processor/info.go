@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// Info сведения об исходном изображении.
+type Info struct {
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Format        string `json:"format"`
+	Bytes         int    `json:"bytes"`
+	HasAlpha      bool   `json:"hasAlpha"`
+	Orientation   int    `json:"orientation"`
+	DominantColor string `json:"dominantColor"`
+}
+
+// dominantColorSample размер уменьшенной копии для поиска основного цвета.
+const dominantColorSample = 64
+
+// GetInfo возвращает сведения об исходном изображении. Размеры, формат и
+// наличие альфа-канала берутся из DecodeConfig; полное декодирование нужно
+// только для основного цвета, поэтому результат кэшируется вместе с вариантами.
+func (p *ImageProcessor) GetInfo(ctx context.Context, url string) (*Info, error) {
+	infoKey := url + "#" + infoOptions
+	if p.variants != nil {
+		cachedData, err := p.variants.Get(ctx, infoKey)
+		if err == nil {
+			defer cachedData.Close()
+			var info Info
+			if err := json.NewDecoder(cachedData).Decode(&info); err != nil {
+				return nil, fmt.Errorf("failed to decode cached info: %w", err)
+			}
+			return &info, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to get info from cache: %w", err)
+		}
+	}
+
+	if err := checkMissGuard(ctx); err != nil {
+		return nil, err
+	}
+
+	data, err := p.GetOriginalData(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %w", err)
+	}
+
+	info := &Info{
+		Width:       config.Width,
+		Height:      config.Height,
+		Format:      format,
+		Bytes:       len(data),
+		HasAlpha:    hasAlpha(config.ColorModel),
+		Orientation: 1,
+	}
+	if format == "jpeg" {
+		if orientation := parseJPEGExif(data).Orientation; orientation != 0 {
+			info.Orientation = orientation
+		}
+	}
+
+	// Декодирование ограничено пулом так же, как обработка
+	var memoryErr error
+	poolErr := p.pool.Do(ctx, func() {
+		var release func()
+		release, memoryErr = p.memory.Acquire(ctx, int64(config.Width)*int64(config.Height)*bytesPerPixel)
+		if memoryErr != nil {
+			return
+		}
+		defer release()
+		var img image.Image
+		img, _, err = image.Decode(bytes.NewReader(data))
+		if err == nil {
+			info.DominantColor = dominantColor(img)
+		}
+	})
+	if poolErr != nil {
+		return nil, poolErr
+	}
+	if memoryErr != nil {
+		return nil, memoryErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if p.variants != nil {
+		record, err := json.Marshal(info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode info: %w", err)
+		}
+		if err := p.variants.Set(ctx, infoKey, record); err != nil {
+			return nil, fmt.Errorf("failed to cache info: %w", err)
+		}
+	}
+
+	return info, nil
+}
+
+// hasAlpha сообщает, может ли цветовая модель хранить прозрачность.
+func hasAlpha(model color.Model) bool {
+	switch model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+		color.AlphaModel, color.Alpha16Model:
+		return true
+	}
+	if palette, ok := model.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dominantColor возвращает основной цвет изображения в виде #rrggbb.
+// Цвета уменьшенной копии группируются по 4 старшим битам каждого канала,
+// результат - средний цвет самой многочисленной группы.
+func dominantColor(img image.Image) string {
+	sample := imaging.Fit(img, dominantColorSample, dominantColorSample, imaging.Box)
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[uint16]*bucket)
+	var best *bucket
+	for i := 0; i+3 < len(sample.Pix); i += 4 {
+		r, g, b, a := int(sample.Pix[i]), int(sample.Pix[i+1]), int(sample.Pix[i+2]), sample.Pix[i+3]
+		if a == 0 {
+			continue
+		}
+		key := uint16(r>>4)<<8 | uint16(g>>4)<<4 | uint16(b>>4)
+		bk, ok := buckets[key]
+		if !ok {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+		if best == nil || bk.count > best.count {
+			best = bk
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
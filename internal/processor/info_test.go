@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/workpool"
+)
+
+func TestGetInfo(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOriginData(t, jpegWithExif(t, 6, "ACME"))
+	p := newTestProcessor(DefaultConfig())
+
+	info, err := p.GetInfo(ctx, url)
+	require.NoError(t, err)
+	assert.Equal(t, 64, info.Width)
+	assert.Equal(t, 32, info.Height)
+	assert.Equal(t, "jpeg", info.Format)
+	assert.Positive(t, info.Bytes)
+	assert.False(t, info.HasAlpha)
+	assert.Equal(t, 6, info.Orientation)
+	assert.Regexp(t, `^#[0-9a-f]{6}$`, info.DominantColor)
+
+	cached, err := p.GetInfo(ctx, url)
+	require.NoError(t, err)
+	assert.Equal(t, info, cached)
+}
+
+func TestGetInfo_AlphaAndDominantColor(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			// Три четверти изображения красные, остальное прозрачное
+			if x < 15 {
+				img.Set(x, y, color.NRGBA{R: 200, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	p := newTestProcessor(DefaultConfig())
+	info, err := p.GetInfo(context.Background(), newTestOriginData(t, buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "png", info.Format)
+	assert.True(t, info.HasAlpha)
+	assert.Equal(t, 1, info.Orientation)
+	assert.Equal(t, "#c80000", info.DominantColor)
+}
+
+func TestGetInfo_RenderPool(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.RenderWorkers = 1
+	config.RenderQueueDepth = 0
+	p := newTestProcessor(config)
+
+	// Единственный исполнитель занят
+	release, started, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.pool.Do(ctx, func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	url := newTestOrigin(t, testImage(64, 64))
+	_, err := p.GetInfo(ctx, url)
+	assert.ErrorIs(t, err, workpool.ErrQueueFull)
+
+	close(release)
+	<-done
+	_, err = p.GetInfo(ctx, url)
+	assert.NoError(t, err)
+}
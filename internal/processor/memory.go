@@ -0,0 +1,258 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// bytesPerPixel оценка памяти на пиксель декодированного изображения:
+// преобразования imaging работают с NRGBA.
+const bytesPerPixel = 4
+
+// progressiveBytesPerPixel память под коэффициенты DCT прогрессивного JPEG:
+// по int32 на каждую из трех компонент.
+const progressiveBytesPerPixel = 12
+
+// ErrOutputTooLarge возвращается, если результат или промежуточное
+// изображение конвейера больше Config.MaxOutputPixels.
+var ErrOutputTooLarge = errors.New("output image is too large")
+
+// estimateMemory оценивает объем памяти для обработки data по размерам из
+// заголовка, не декодируя изображение: исходник, который занят до конца
+// обработки, наибольший шаг конвейера и буферы кодирования. Если
+// изображение на одном из шагов больше maxPixels (0 - без ограничения),
+// возвращает ErrOutputTooLarge.
+func estimateMemory(data []byte, opts Options, maxPixels int) (int64, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	pixels := int64(config.Width) * int64(config.Height)
+
+	ops := opts.Operations
+	if format == "jpeg" && opts.AutoOrient != nil && *opts.AutoOrient {
+		// Ориентация из EXIF применяется отдельным шагом до конвейера
+		if op := orientationOperation(parseJPEGExif(data).Orientation); op != nil {
+			ops = append([]Operation{op}, ops...)
+		}
+	}
+	plan, err := planPipeline(config.Width, config.Height, ops, int64(maxPixels))
+	if err != nil {
+		return 0, err
+	}
+
+	if format == "gif" && (opts.Format == "" || opts.Format == FormatGIF) && opts.MaxFrames != 1 {
+		// Все кадры анимации декодируются в палитровые изображения,
+		// используемые кадры собираются в полноцветные вместе с холстом и
+		// копией для удаления кадра, обрабатываются по одному и хранятся
+		// до кодирования вместе с палитровыми копиями и результатом
+		if frames := gifFrameCount(data); frames > 1 {
+			used := int64(frames)
+			if opts.MaxFrames > 0 {
+				used = min(used, int64(opts.MaxFrames))
+			}
+			return pixels*int64(frames) + (used+2)*pixels*bytesPerPixel +
+				plan.peak + used*plan.pixels*(bytesPerPixel+2), nil
+		}
+	}
+	return pixels*bytesPerPixel + plan.peak + encodeMemory(plan.pixels, opts), nil
+}
+
+// encodeMemory оценивает буферы кодирования результата из pixels пикселей:
+// закодированные данные в худшем случае (PNG без сжатия), для maxbytes -
+// лучшая и текущая попытки, а также коэффициенты прогрессивного JPEG или
+// палитровая копия для GIF.
+func encodeMemory(pixels int64, opts Options) int64 {
+	size := pixels * bytesPerPixel
+	if opts.MaxBytes > 0 {
+		size *= 2
+	}
+	switch {
+	case opts.Format == FormatGIF:
+		size += pixels
+	case opts.Progressive:
+		size += pixels * progressiveBytesPerPixel
+	}
+	return size
+}
+
+// pipelinePlan размеры результата конвейера и наибольший объем памяти,
+// занятый одним шагом.
+type pipelinePlan struct {
+	width, height int
+	pixels        int64
+	peak          int64
+}
+
+// planPipeline проходит операции по размерам изображения width x height.
+// Шаг занимает память под промежуточные изображения и результат, а также
+// под свой вход, если это не исходник, который учитывается отдельно.
+func planPipeline(width, height int, ops []Operation, maxPixels int64) (pipelinePlan, error) {
+	plan := pipelinePlan{width: width, height: height, pixels: int64(width) * int64(height)}
+	for i, op := range ops {
+		w, h, temps := stepSize(op, plan.width, plan.height)
+		pixels := int64(w) * int64(h)
+		var step int64
+		for _, size := range append(temps, pixels) {
+			if maxPixels > 0 && size > maxPixels {
+				return plan, fmt.Errorf("%w: %s needs %d pixels, limit is %d", ErrOutputTooLarge, op.Name(), size, maxPixels)
+			}
+			step += size
+		}
+		if i > 0 {
+			step += plan.pixels
+		}
+		plan.peak = max(plan.peak, step*bytesPerPixel)
+		plan.width, plan.height, plan.pixels = w, h, pixels
+	}
+	return plan, nil
+}
+
+// stepSize возвращает размеры результата операции над изображением
+// width x height и площади промежуточных изображений, которые создает
+// imaging.
+func stepSize(op Operation, width, height int) (int, int, []int64) {
+	switch op := op.(type) {
+	case Resize:
+		return resizeSize(op, width, height)
+	case Rotate:
+		if op.rightAngle() {
+			if op == 90 || op == 270 {
+				return height, width, nil
+			}
+			return width, height, nil
+		}
+		sin, cos := math.Sincos(float64(op) * math.Pi / 180)
+		sin, cos = math.Abs(sin), math.Abs(cos)
+		w := math.Ceil(float64(width)*cos + float64(height)*sin)
+		h := math.Ceil(float64(width)*sin + float64(height)*cos)
+		return int(w), int(h), nil
+	case Transpose, Transverse:
+		return height, width, nil
+	case Blur, Sharpen:
+		// Размытие выполняется в два прохода через промежуточное изображение
+		return width, height, []int64{int64(width) * int64(height)}
+	}
+	return width, height, nil
+}
+
+// resizeSize повторяет выбор размеров imaging.Resize, Fit и Fill. Resize
+// с изменением обеих сторон проходит через изображение новой ширины и
+// исходной высоты, Fill сначала масштабирует изображение с запасом, а
+// затем обрезает его.
+func resizeSize(r Resize, width, height int) (int, int, []int64) {
+	w, h := r.Width, r.Height
+	if w > 0 && h > 0 {
+		switch r.Type {
+		case ResizeFit:
+			if width <= w && height <= h {
+				return width, height, nil
+			}
+			if float64(width)/float64(height) > float64(w)/float64(h) {
+				h = 0
+			} else {
+				w = 0
+			}
+		case ResizeFill:
+			if width == w && height == h {
+				return w, h, nil
+			}
+			coverW, coverH, temps := resizeSize(Resize{Type: ResizeForce, Width: w}, width, height)
+			if float64(width)/float64(height) > float64(w)/float64(h) {
+				coverW, coverH, temps = resizeSize(Resize{Type: ResizeForce, Height: h}, width, height)
+			}
+			return w, h, append(temps, int64(coverW)*int64(coverH))
+		}
+	}
+	if w == 0 {
+		w = max(1, int(math.Round(float64(h)*float64(width)/float64(height))))
+	}
+	if h == 0 {
+		h = max(1, int(math.Round(float64(w)*float64(height)/float64(width))))
+	}
+	if w != width && h != height {
+		return w, h, []int64{int64(w) * int64(height)}
+	}
+	return w, h, nil
+}
+
+// orientationOperation возвращает операцию, которая применяет ориентацию
+// EXIF, или nil, если поворот не нужен.
+func orientationOperation(orientation int) Operation {
+	switch orientation {
+	case 2:
+		return FlipH{}
+	case 3:
+		return Rotate(180)
+	case 4:
+		return FlipV{}
+	case 5:
+		return Transpose{}
+	case 6:
+		return Rotate(90)
+	case 7:
+		return Transverse{}
+	case 8:
+		return Rotate(270)
+	}
+	return nil
+}
+
+// gifFrameCount считает кадры GIF по блокам файла без декодирования.
+// Для поврежденного файла возвращает число кадров до ошибки.
+func gifFrameCount(data []byte) int {
+	const headerLen = 13
+	if len(data) < headerLen {
+		return 0
+	}
+	pos := headerLen
+	// Глобальная палитра
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks пропускает последовательность блоков данных до пустого
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // расширение
+			pos += 2
+			if !skipSubBlocks() {
+				return frames
+			}
+		case 0x2c: // дескриптор изображения
+			const descriptorLen = 10
+			if pos+descriptorLen > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += descriptorLen
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			// Минимальный размер кода LZW и данные кадра
+			pos++
+			if !skipSubBlocks() {
+				return frames
+			}
+			frames++
+		default: // завершающий блок 0x3b или мусор
+			return frames
+		}
+	}
+	return frames
+}
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/membudget"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	data := animatedGIF(t)
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	require.NoError(t, err)
+	frames := int64(len(g.Image))
+	assert.Equal(t, int(frames), gifFrameCount(data))
+	assert.Equal(t, int(frames)-1, gifFrameCount(data[:len(data)-10]))
+
+	const pixels = 40 * 40
+	size, err := estimateMemory(data, Options{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, pixels*frames+(frames+2)*pixels*bytesPerPixel+frames*pixels*(bytesPerPixel+2), size)
+	size, err = estimateMemory(data, Options{MaxFrames: 1}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*pixels*bytesPerPixel), size)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(30, 20)))
+	size, err = estimateMemory(buf.Bytes(), Options{Operations: resize(60, 40)}, 0)
+	require.NoError(t, err)
+	// Исходник, шаг с промежуточным изображением 60x20 и буфер кодирования
+	assert.Equal(t, int64((30*20+60*20+60*40+60*40)*bytesPerPixel), size)
+	size, err = estimateMemory(buf.Bytes(), Options{Operations: resize(60, 40), Format: FormatJPEG, Progressive: true}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64((30*20+60*20+60*40+60*40)*bytesPerPixel+60*40*progressiveBytesPerPixel), size)
+
+	_, err = estimateMemory(buf.Bytes(), Options{Operations: resize(100000, 100000)}, 50_000_000)
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+}
+
+func TestPlanPipeline(t *testing.T) {
+	tests := []struct {
+		name          string
+		ops           []Operation
+		width, height int
+		peak          int64
+	}{
+		{"no operations", nil, 100, 50, 0},
+		{"force", resize(200, 200), 200, 200, 200*200 + 200*50},
+		{"fit without upscale", []Operation{Resize{Type: ResizeFit, Width: 1000, Height: 1000}}, 100, 50, 100 * 50},
+		{"fit", []Operation{Resize{Type: ResizeFit, Width: 50, Height: 50}}, 50, 25, 50*25 + 50*50},
+		{"fill", []Operation{Resize{Type: ResizeFill, Width: 50, Height: 50}}, 50, 50, 50*50 + 100*50},
+		{"blur after resize", resize(200, 200, Blur(2)), 200, 200, 3 * 200 * 200},
+		{"rotate", []Operation{NewRotate(90)}, 50, 100, 100 * 50},
+		{"free rotate", []Operation{NewRotate(45)}, 107, 107, 107 * 107},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planPipeline(100, 50, tt.ops, 0)
+			require.NoError(t, err)
+			assert.Equal(t, []int{tt.width, tt.height}, []int{plan.width, plan.height})
+			assert.Equal(t, int64(tt.width*tt.height), plan.pixels)
+			assert.Equal(t, tt.peak*bytesPerPixel, plan.peak)
+		})
+	}
+
+	// Промежуточное изображение тоже ограничено
+	_, err := planPipeline(10, 1000, []Operation{Resize{Type: ResizeFill, Width: 100, Height: 100}}, 50_000)
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+}
+
+func TestProcessImage_MemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	// Исходник, шаг масштабирования до 32x32 и буфер кодирования
+	config.MemoryBudget = (64*64 + 32*64 + 32*32 + 32*32) * bytesPerPixel
+	p := newTestProcessor(config)
+
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), p.MemoryStats().Used)
+
+	config.MemoryBudget--
+	p = newTestProcessor(config)
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	assert.ErrorIs(t, err, membudget.ErrExceedsBudget)
+
+	config.MaxOutputPixels = 32 * 32
+	p = newTestProcessor(config)
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(64, 64)})
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+}
+
+func TestProcessImage_MemoryAfterPool(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	config.RenderWorkers = 1
+	config.RenderQueueDepth = 1
+	config.MemoryBudget = 1 << 30
+	p := newTestProcessor(config)
+
+	// Единственный исполнитель занят
+	release, started := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = p.pool.Do(ctx, func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return p.PoolStats().Queued == 1 }, time.Second, time.Millisecond)
+	// Ожидающая в очереди обработка не занимает бюджет
+	assert.Equal(t, int64(0), p.MemoryStats().Used)
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(0), p.MemoryStats().Used)
+}
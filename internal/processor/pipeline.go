@@ -0,0 +1,276 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Конвейер обработки задается в пути запроса сегментами name:arg1:arg2...
+// Сегмент plain отделяет параметры от URL изображения:
+//
+//	/p/rs:fill:300:200/g:ce/q:80/f:png/plain/{url}
+//
+// Операции (масштабирование, повороты, коррекция) выполняются в порядке
+// указания. Настройки (формат, качество, гравитация и т.п.) от порядка не
+// зависят. Каждое имя может встречаться только один раз.
+const plainSegment = "plain"
+
+// Operation шаг конвейера обработки изображения.
+type Operation interface {
+	// Name каноническое короткое имя операции в пути.
+	Name() string
+	// Args канонические аргументы операции.
+	Args() []string
+
+	validate() error
+	apply(img image.Image, env *stepEnv) image.Image
+}
+
+// stepEnv общие для всех шагов параметры, которые задаются настройками.
+type stepEnv struct {
+	filter     imaging.ResampleFilter
+	gravity    imaging.Anchor
+	background color.NRGBA
+}
+
+// optionAliases длинные имена операций и настроек.
+var optionAliases = map[string]string{
+	"resize":      "rs",
+	"rotate":      "rot",
+	"fliph":       "fh",
+	"flipv":       "fv",
+	"transpose":   "tp",
+	"transverse":  "tv",
+	"brightness":  "br",
+	"contrast":    "co",
+	"gamma":       "ga",
+	"saturation":  "sa",
+	"grayscale":   "gs",
+	"invert":      "inv",
+	"blur":        "bl",
+	"sharpen":     "sh",
+	"gravity":     "g",
+	"background":  "bg",
+	"filter":      "rf",
+	"autoorient":  "ao",
+	"frames":      "fr",
+	"format":      "f",
+	"quality":     "q",
+	"progressive": "pj",
+	"compression": "pc",
+	"maxbytes":    "mb",
+}
+
+// operationParsers создают операции по аргументам. Флаги без аргументов
+// принимают необязательное логическое значение; при false операция не
+// добавляется, и парсер возвращает nil.
+var operationParsers = map[string]func(args []string) (Operation, error){
+	"rs":  parseResize,
+	"rot": floatOperation("rot", func(v float64) Operation { return NewRotate(v) }),
+	"fh":  flagOperation("fh", FlipH{}),
+	"fv":  flagOperation("fv", FlipV{}),
+	"tp":  flagOperation("tp", Transpose{}),
+	"tv":  flagOperation("tv", Transverse{}),
+	"br":  floatOperation("br", func(v float64) Operation { return Brightness(v) }),
+	"co":  floatOperation("co", func(v float64) Operation { return Contrast(v) }),
+	"ga":  floatOperation("ga", func(v float64) Operation { return Gamma(v) }),
+	"sa":  floatOperation("sa", func(v float64) Operation { return Saturation(v) }),
+	"gs":  flagOperation("gs", Grayscale{}),
+	"inv": flagOperation("inv", Invert{}),
+	"bl":  floatOperation("bl", func(v float64) Operation { return Blur(v) }),
+	"sh":  floatOperation("sh", func(v float64) Operation { return Sharpen(v) }),
+}
+
+// settingParsers записывают настройки в Options.
+var settingParsers = map[string]func(opts *Options, args []string) error{
+	"g": stringSetting("g", func(opts *Options, v string) { opts.Gravity = v }),
+	"bg": stringSetting("bg", func(opts *Options, v string) {
+		opts.Background = strings.ToLower(v)
+	}),
+	"rf": stringSetting("rf", func(opts *Options, v string) { opts.Filter = v }),
+	"ao": func(opts *Options, args []string) error {
+		autoOrient, err := parseFlag("ao", args)
+		opts.AutoOrient = &autoOrient
+		return err
+	},
+	"fr": intSetting("fr", func(opts *Options, v int) { opts.MaxFrames = v }),
+	"f":  stringSetting("f", func(opts *Options, v string) { opts.Format = Format(v) }),
+	"q":  intSetting("q", func(opts *Options, v int) { opts.Quality = v }),
+	"pj": func(opts *Options, args []string) error {
+		var err error
+		opts.Progressive, err = parseFlag("pj", args)
+		return err
+	},
+	"pc": stringSetting("pc", func(opts *Options, v string) { opts.PNGCompression = v }),
+	"mb": intSetting("mb", func(opts *Options, v int) { opts.MaxBytes = v }),
+}
+
+// IsOptionName сообщает, является ли name именем операции или настройки.
+func IsOptionName(name string) bool {
+	if alias, ok := optionAliases[name]; ok {
+		name = alias
+	}
+	_, isOperation := operationParsers[name]
+	_, isSetting := settingParsers[name]
+	return isOperation || isSetting
+}
+
+// ParsePipeline разбирает сегменты пути до сегмента plain. Возвращает
+// параметры обработки и оставшиеся сегменты, из которых состоит URL.
+func ParsePipeline(segments []string) (Options, []string, error) {
+	var opts Options
+	seen := make(map[string]bool)
+	for i, segment := range segments {
+		if segment == plainSegment {
+			opts.explicit = seen
+			return opts, segments[i+1:], nil
+		}
+
+		args := strings.Split(segment, ":")
+		name := args[0]
+		if alias, ok := optionAliases[name]; ok {
+			name = alias
+		}
+		if seen[name] {
+			return opts, nil, fmt.Errorf("%w: duplicate option %q", ErrInvalidOptions, args[0])
+		}
+		seen[name] = true
+
+		if parse, ok := operationParsers[name]; ok {
+			op, err := parse(args[1:])
+			if err != nil {
+				return opts, nil, err
+			}
+			if op != nil {
+				opts.Operations = append(opts.Operations, op)
+			}
+			continue
+		}
+		if parse, ok := settingParsers[name]; ok {
+			if err := parse(&opts, args[1:]); err != nil {
+				return opts, nil, err
+			}
+			continue
+		}
+		return opts, nil, fmt.Errorf("%w: unknown option %q", ErrInvalidOptions, args[0])
+	}
+	return opts, nil, fmt.Errorf("%w: missing %q segment before source URL", ErrInvalidOptions, plainSegment)
+}
+
+// String возвращает каноническую запись параметров: операции в порядке
+// выполнения, затем заданные настройки в фиксированном порядке.
+func (o Options) String() string {
+	parts := make([]string, 0, len(o.Operations)+10)
+	for _, op := range o.Operations {
+		parts = append(parts, strings.Join(append([]string{op.Name()}, op.Args()...), ":"))
+	}
+	add := func(name, value string) {
+		if value != "" {
+			parts = append(parts, name+":"+value)
+		}
+	}
+	add("g", o.Gravity)
+	add("bg", o.Background)
+	add("rf", o.Filter)
+	if o.AutoOrient != nil {
+		add("ao", formatFlag(*o.AutoOrient))
+	}
+	if o.MaxFrames > 0 {
+		add("fr", strconv.Itoa(o.MaxFrames))
+	}
+	add("f", string(o.Format))
+	if o.Quality > 0 {
+		add("q", strconv.Itoa(o.Quality))
+	}
+	if o.Progressive {
+		add("pj", formatFlag(true))
+	}
+	add("pc", o.PNGCompression)
+	if o.MaxBytes > 0 {
+		add("mb", strconv.Itoa(o.MaxBytes))
+	}
+	return strings.Join(parts, "/")
+}
+
+func argsError(name string, want int, args []string) error {
+	return fmt.Errorf("%w: %s expects %d argument(s), got %d", ErrInvalidOptions, name, want, len(args))
+}
+
+func floatOperation(name string, create func(v float64) Operation) func(args []string) (Operation, error) {
+	return func(args []string) (Operation, error) {
+		if len(args) != 1 {
+			return nil, argsError(name, 1, args)
+		}
+		v, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%w: invalid %s value %q", ErrInvalidOptions, name, args[0])
+		}
+		return create(v), nil
+	}
+}
+
+func flagOperation(name string, op Operation) func(args []string) (Operation, error) {
+	return func(args []string) (Operation, error) {
+		on, err := parseFlag(name, args)
+		if err != nil || !on {
+			return nil, err
+		}
+		return op, nil
+	}
+}
+
+// parseFlag: флаг без аргумента включен, иначе аргумент - логическое значение.
+func parseFlag(name string, args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return true, nil
+	case 1:
+		on, err := strconv.ParseBool(args[0])
+		if err != nil {
+			return false, fmt.Errorf("%w: invalid %s value %q", ErrInvalidOptions, name, args[0])
+		}
+		return on, nil
+	}
+	return false, argsError(name, 1, args)
+}
+
+func formatFlag(on bool) string {
+	if on {
+		return "1"
+	}
+	return "0"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func stringSetting(name string, set func(opts *Options, v string)) func(opts *Options, args []string) error {
+	return func(opts *Options, args []string) error {
+		if len(args) != 1 {
+			return argsError(name, 1, args)
+		}
+		set(opts, args[0])
+		return nil
+	}
+}
+
+func intSetting(name string, set func(opts *Options, v int)) func(opts *Options, args []string) error {
+	return func(opts *Options, args []string) error {
+		if len(args) != 1 {
+			return argsError(name, 1, args)
+		}
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("%w: invalid %s value %q", ErrInvalidOptions, name, args[0])
+		}
+		set(opts, v)
+		return nil
+	}
+}
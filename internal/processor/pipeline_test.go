@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePipeline(t *testing.T) {
+	path := "rs:fill:300:200/rotate:90/g:no/q:80/f:png/gs/plain/localhost:8080/images/1.jpg"
+	opts, rest, err := ParsePipeline(strings.Split(path, "/"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Operation{Resize{Type: ResizeFill, Width: 300, Height: 200}, NewRotate(90), Grayscale{}}, opts.Operations)
+	assert.Equal(t, "no", opts.Gravity)
+	assert.Equal(t, 80, opts.Quality)
+	assert.Equal(t, FormatPNG, opts.Format)
+	assert.Equal(t, []string{"localhost:8080", "images", "1.jpg"}, rest)
+	assert.Equal(t, "rs:fill:300:200/rot:90/gs/g:no/f:png/q:80", opts.String())
+
+	// Каноническая запись разбирается в те же параметры
+	again, _, err := ParsePipeline(append(strings.Split(opts.String(), "/"), plainSegment))
+	require.NoError(t, err)
+	assert.Equal(t, opts, again)
+}
+
+func TestParsePipeline_Errors(t *testing.T) {
+	testCases := []struct {
+		name string
+		path string
+	}{
+		{"Duplicate", "q:80/q:90/plain/host/1.jpg"},
+		{"Duplicate alias", "quality:80/q:90/plain/host/1.jpg"},
+		{"Unknown", "zoom:2/plain/host/1.jpg"},
+		{"Missing plain", "rs:fit:10:10/host/1.jpg"},
+		{"Wrong arguments", "rs:fit:10/plain/host/1.jpg"},
+		{"Invalid number", "br:much/plain/host/1.jpg"},
+		{"Invalid flag", "gs:maybe/plain/host/1.jpg"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := ParsePipeline(strings.Split(tc.path, "/"))
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
+
+func TestNormalize_CanonicalKey(t *testing.T) {
+	p := newTestProcessor(DefaultConfig())
+	parse := func(path string) string {
+		opts, _, err := ParsePipeline(strings.Split(path, "/"))
+		require.NoError(t, err)
+		opts, err = p.normalize(opts)
+		require.NoError(t, err)
+		return opts.Key()
+	}
+
+	// Явно заданные значения по умолчанию и лишние настройки не меняют ключ
+	assert.Equal(t, parse("rs:fill:10:10/plain"), parse("g:ce/resize:fill:10:10/q:85/plain"))
+	assert.Equal(t, parse("rs:fit:10:10/f:png/plain"), parse("rs:fit:10:10/g:so/q:50/pj/f:png/plain"))
+	assert.Equal(t, parse("rs:fit:10:10/plain"), parse("rs:fit:10:10/gs:0/plain"))
+	assert.NotEqual(t, parse("rs:fill:10:10/plain"), parse("rs:fill:10:10/g:no/plain"))
+	// Порядок операций важен
+	assert.NotEqual(t, parse("rot:45/rs:fit:10:10/plain"), parse("rs:fit:10:10/rot:45/plain"))
+
+	_, err := p.normalize(Options{Operations: []Operation{Resize{Type: ResizeFill, Width: 10, Height: 10}}, Gravity: "up"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = p.normalize(Options{Operations: []Operation{Resize{Type: "crop", Width: 10, Height: 10}}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestProcessImage_ResizeTypes(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, markedImage()))
+	url := newTestOriginData(t, buf.Bytes())
+	p := newTestProcessor(DefaultConfig())
+
+	testCases := []struct {
+		name string
+		op   Resize
+		size image.Point
+	}{
+		{"Fit", Resize{Type: ResizeFit, Width: 20, Height: 20}, image.Pt(20, 10)},
+		{"Fill", Resize{Type: ResizeFill, Width: 20, Height: 20}, image.Pt(20, 20)},
+		{"Force", Resize{Type: ResizeForce, Width: 20, Height: 20}, image.Pt(20, 20)},
+		{"Width only", Resize{Type: ResizeFit, Width: 20}, image.Pt(20, 10)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := p.ProcessImage(context.Background(), url, Options{Operations: []Operation{tc.op}, Format: FormatPNG})
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(result.Data))
+			require.NoError(t, err)
+			assert.Equal(t, tc.size, img.Bounds().Size())
+		})
+	}
+}
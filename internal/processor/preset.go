@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// presetName допустимые имена пресетов.
+var presetName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ParsePreset проверяет имя пресета и разбирает его конвейер. Конвейер
+// записывается так же, как в пути /p/, без сегмента plain:
+//
+//	rs:fill:150:150/q:80
+func ParsePreset(name, pipeline string) (Options, error) {
+	if !presetName.MatchString(name) {
+		return Options{}, fmt.Errorf("invalid preset name: %q", name)
+	}
+	opts, _, err := ParsePipeline(append(strings.Split(pipeline, "/"), plainSegment))
+	if err != nil {
+		return Options{}, fmt.Errorf("invalid preset %q: %w", name, err)
+	}
+	return opts, nil
+}
+
+// Override накладывает параметры запроса на параметры пресета. Заданные
+// настройки, в том числе выключенные или нулевые (pj:0, mb:0), заменяют
+// настройки пресета. Операция заменяет одноименную операцию пресета на ее
+// месте, остальные добавляются в конец конвейера. Выключенная операция
+// (gs:0) удаляется из конвейера пресета.
+func (o Options) Override(overrides Options) Options {
+	o.Operations = slices.DeleteFunc(slices.Clone(o.Operations), func(presetOp Operation) bool {
+		return overrides.explicit[presetOp.Name()] && !slices.ContainsFunc(overrides.Operations, func(op Operation) bool {
+			return op.Name() == presetOp.Name()
+		})
+	})
+	for _, op := range overrides.Operations {
+		i := slices.IndexFunc(o.Operations, func(presetOp Operation) bool { return presetOp.Name() == op.Name() })
+		if i < 0 {
+			o.Operations = append(o.Operations, op)
+		} else {
+			o.Operations[i] = op
+		}
+	}
+
+	override := func(name string, set func()) {
+		if overrides.explicit[name] {
+			set()
+		}
+	}
+	override("g", func() { o.Gravity = overrides.Gravity })
+	override("bg", func() { o.Background = overrides.Background })
+	override("rf", func() { o.Filter = overrides.Filter })
+	override("ao", func() { o.AutoOrient = overrides.AutoOrient })
+	override("fr", func() { o.MaxFrames = overrides.MaxFrames })
+	override("f", func() { o.Format = overrides.Format })
+	override("q", func() { o.Quality = overrides.Quality })
+	override("pj", func() { o.Progressive = overrides.Progressive })
+	override("pc", func() { o.PNGCompression = overrides.PNGCompression })
+	override("mb", func() { o.MaxBytes = overrides.MaxBytes })
+
+	explicit := maps.Clone(o.explicit)
+	if explicit == nil {
+		explicit = make(map[string]bool, len(overrides.explicit))
+	}
+	maps.Copy(explicit, overrides.explicit)
+	if len(explicit) > 0 {
+		o.explicit = explicit
+	}
+	return o
+}
+
+// Preset возвращает параметры пресета name.
+func (p *ImageProcessor) Preset(name string) (Options, bool) {
+	opts, ok := p.currentConfig().Presets[name]
+	return opts, ok
+}
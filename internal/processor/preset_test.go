@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreset(t *testing.T) {
+	thumb, err := ParsePreset("thumb", "rs:fill:150:150/q:80")
+	require.NoError(t, err)
+	assert.Equal(t, "rs:fill:150:150/q:80", thumb.String())
+	hero, err := ParsePreset("hero", "resize:fit:1920:0/pj")
+	require.NoError(t, err)
+	assert.Equal(t, "rs:fit:1920:0/pj:1", hero.String())
+
+	for name, pipeline := range map[string]string{"Thumb": "q:80", "thumb": "zoom:2", "card": "rs:fill:150"} {
+		_, err := ParsePreset(name, pipeline)
+		assert.Error(t, err, name)
+	}
+
+	config := DefaultConfig()
+	invalid, err := ParsePreset("thumb", "rs:fill:150:150/q:101")
+	require.NoError(t, err)
+	config.Presets = map[string]Options{"thumb": invalid}
+	assert.ErrorIs(t, config.Validate(), ErrInvalidOptions)
+}
+
+func TestOptions_Override(t *testing.T) {
+	preset, err := ParsePreset("card", "rot:90/rs:fill:300:200/gs/q:80/f:jpeg")
+	require.NoError(t, err)
+
+	overrides, _, err := ParsePipeline([]string{"rs:fit:600:400", "bl:2", "q:60", "plain"})
+	require.NoError(t, err)
+	assert.Equal(t, "rot:90/rs:fit:600:400/gs/bl:2/f:jpeg/q:60", preset.Override(overrides).String())
+
+	// Сам пресет не меняется
+	assert.Equal(t, "rot:90/rs:fill:300:200/gs/f:jpeg/q:80", preset.String())
+	assert.Equal(t, preset, preset.Override(Options{}))
+}
+
+func TestOptions_OverrideDisable(t *testing.T) {
+	preset, err := ParsePreset("hero", "rs:fit:1920:0/gs/pj/mb:200000/q:70")
+	require.NoError(t, err)
+
+	// Выключенные и нулевые значения заменяют настройки пресета
+	overrides, _, err := ParsePipeline([]string{"pj:0", "mb:0", "gs:false", "plain"})
+	require.NoError(t, err)
+	opts := preset.Override(overrides)
+	assert.False(t, opts.Progressive)
+	assert.Zero(t, opts.MaxBytes)
+	assert.Equal(t, "rs:fit:1920:0/q:70", opts.String())
+
+	// Незаданные настройки остаются от пресета
+	overrides, _, err = ParsePipeline([]string{"q:50", "plain"})
+	require.NoError(t, err)
+	assert.Equal(t, "rs:fit:1920:0/gs/q:50/pj:1/mb:200000", preset.Override(overrides).String())
+}
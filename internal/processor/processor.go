@@ -3,53 +3,409 @@ package processor
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"imageproxy/internal/cache"
+	"imageproxy/internal/membudget"
+	"imageproxy/internal/metrics"
+	"imageproxy/internal/storage"
+	"imageproxy/internal/workpool"
 )
 
+// tracer создает spans стадий обработки.
+var tracer = otel.Tracer("imageproxy/internal/processor")
+
+// ErrInvalidOptions возвращается, если параметры запроса не прошли проверку.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Format формат выходного изображения.
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+)
+
+// pngCompressionLevels допустимые уровни сжатия PNG.
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// Config настройки обработчика по умолчанию и допустимые границы.
+type Config struct {
+	DefaultQuality int
+	MinQuality     int
+	MaxQuality     int
+	PNGCompression string
+	// MaxBytesPolicy определяет поведение, если результат не укладывается
+	// в maxbytes при минимальном качестве: MaxBytesFail или MaxBytesDownscale.
+	MaxBytesPolicy string
+	// DefaultFilter фильтр масштабирования по умолчанию.
+	DefaultFilter string
+	// ExpensiveFilterMaxPixels максимальная площадь исходного изображения,
+	// для которой разрешены дорогие фильтры (0 - без ограничения). Для больших
+	// изображений вместо них используется FallbackFilter.
+	ExpensiveFilterMaxPixels int
+	FallbackFilter           string
+	// AutoOrient применяет ориентацию из EXIF при декодировании.
+	AutoOrient bool
+	// KeepCopyrightOrigins источники (host[:port]), для которых в результате
+	// сохраняются поля EXIF Artist и Copyright. Остальные метаданные
+	// всегда удаляются.
+	KeepCopyrightOrigins []string
+	// MetricsOrigins источники (host[:port]), загрузки с которых учитываются
+	// в метриках под своим именем. Остальные источники объединяются под
+	// меткой OtherOrigin, чтобы клиенты не могли создавать новые ряды.
+	MetricsOrigins []string
+	// MaxFrames максимальное число кадров анимации в результате.
+	MaxFrames int
+	// MaxOutputPixels максимальная площадь результата и промежуточных
+	// изображений конвейера (0 - без ограничения).
+	MaxOutputPixels int
+	// Presets именованные наборы параметров обработки.
+	Presets map[string]Options
+	// RenderWorkers число одновременных обработок, RenderQueueDepth
+	// и RenderQueueTimeout - размер очереди ожидающих обработок и время
+	// ожидания в ней.
+	RenderWorkers      int
+	RenderQueueDepth   int
+	RenderQueueTimeout time.Duration
+	// MemoryBudget максимальный суммарный объем памяти одновременных
+	// обработок в байтах (0 - без ограничения). Обработки, которые не помещаются
+	// в бюджет, ждут не дольше MemoryBudgetTimeout.
+	MemoryBudget        int64
+	MemoryBudgetTimeout time.Duration
+}
+
+// DefaultConfig возвращает конфигурацию по умолчанию.
+func DefaultConfig() Config {
+	return Config{
+		DefaultQuality: 85,
+		MinQuality:     1,
+		MaxQuality:     100,
+		PNGCompression: "default",
+		MaxBytesPolicy: MaxBytesFail,
+		DefaultFilter:  "lanczos",
+		FallbackFilter: "linear",
+		AutoOrient:     true,
+		MaxFrames:      100,
+
+		MaxOutputPixels: 50_000_000,
+
+		RenderWorkers:      runtime.GOMAXPROCS(0),
+		RenderQueueDepth:   4 * runtime.GOMAXPROCS(0),
+		RenderQueueTimeout: 5 * time.Second,
+
+		MemoryBudget:        1 << 30,
+		MemoryBudgetTimeout: 5 * time.Second,
+	}
+}
+
+// Validate проверяет согласованность настроек.
+func (c Config) Validate() error {
+	if c.MinQuality < 1 || c.MaxQuality > 100 || c.MinQuality > c.MaxQuality {
+		return fmt.Errorf("invalid quality bounds: %d..%d", c.MinQuality, c.MaxQuality)
+	}
+	if c.DefaultQuality < c.MinQuality || c.DefaultQuality > c.MaxQuality {
+		return fmt.Errorf("default quality %d is out of bounds %d..%d", c.DefaultQuality, c.MinQuality, c.MaxQuality)
+	}
+	if _, ok := pngCompressionLevels[c.PNGCompression]; !ok {
+		return fmt.Errorf("unknown png compression level: %q", c.PNGCompression)
+	}
+	if c.MaxBytesPolicy != MaxBytesFail && c.MaxBytesPolicy != MaxBytesDownscale {
+		return fmt.Errorf("unknown maxbytes policy: %q", c.MaxBytesPolicy)
+	}
+	if _, ok := resampleFilters[c.DefaultFilter]; !ok {
+		return fmt.Errorf("unknown default filter: %q", c.DefaultFilter)
+	}
+	if filter, ok := resampleFilters[c.FallbackFilter]; !ok || filter.Support > expensiveFilterSupport {
+		return fmt.Errorf("fallback filter must be a cheap one: %q", c.FallbackFilter)
+	}
+	if c.MaxFrames < 1 {
+		return fmt.Errorf("max frames must be positive: %d", c.MaxFrames)
+	}
+	if c.ExpensiveFilterMaxPixels < 0 {
+		return fmt.Errorf("negative expensive filter threshold: %d", c.ExpensiveFilterMaxPixels)
+	}
+	if c.MaxOutputPixels < 0 {
+		return fmt.Errorf("negative max output pixels: %d", c.MaxOutputPixels)
+	}
+	if c.RenderWorkers < 1 || c.RenderQueueDepth < 0 || c.RenderQueueTimeout <= 0 {
+		return fmt.Errorf("invalid render pool: %d workers, queue depth %d, queue timeout %s",
+			c.RenderWorkers, c.RenderQueueDepth, c.RenderQueueTimeout)
+	}
+	if c.MemoryBudget < 0 || c.MemoryBudget > 0 && c.MemoryBudgetTimeout <= 0 {
+		return fmt.Errorf("invalid memory budget: %d bytes, timeout %s", c.MemoryBudget, c.MemoryBudgetTimeout)
+	}
+	p := &ImageProcessor{}
+	p.config.Store(&c)
+	for name, opts := range c.Presets {
+		if _, err := p.normalize(opts); err != nil {
+			return fmt.Errorf("invalid preset %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Options параметры обработки одного изображения: операции конвейера и
+// настройки. Нулевые значения настроек заменяются значениями из Config.
+type Options struct {
+	// Operations шаги обработки в порядке выполнения.
+	Operations []Operation
+	// Gravity сторона, к которой прижимается кадр при ResizeFill.
+	Gravity string
+	// Background цвет фона (RRGGBB или RRGGBBAA) для поворотов на углы,
+	// не кратные 90. По умолчанию фон прозрачный.
+	Background string
+	// Format формат результата. Пустое значение означает анимированный GIF
+	// для анимированных исходников и JPEG для остальных.
+	Format         Format
+	Quality        int
+	Progressive    bool
+	PNGCompression string
+	// MaxBytes ограничение размера результата в байтах, 0 - без ограничения.
+	MaxBytes int
+	// Filter имя фильтра масштабирования из resampleFilters.
+	Filter string
+	// AutoOrient переопределяет Config.AutoOrient, если задан.
+	AutoOrient *bool
+	// MaxFrames ограничивает число кадров анимации, 1 - только первый кадр.
+	MaxFrames int
+
+	// explicit имена операций и настроек, заданных при разборе, в том числе
+	// выключенных, например pj:0. По ним Override отличает выключенную
+	// настройку от незаданной.
+	explicit map[string]bool
+}
+
+// Key возвращает ключ кэша вариантов - каноническую запись нормализованных
+// параметров.
+func (o Options) Key() string {
+	return o.String()
+}
+
+// ContentType возвращает MIME-тип результата.
+func (o Options) ContentType() string {
+	switch o.Format {
+	case FormatPNG:
+		return "image/png"
+	case FormatGIF:
+		return "image/gif"
+	case FormatJPEG:
+	}
+	return "image/jpeg"
+}
+
+// CacheStatus показывает, откуда взят результат обработки.
+type CacheStatus string
+
+const (
+	// CacheHit результат взят из кэша вариантов.
+	CacheHit CacheStatus = "hit"
+	// CacheMiss результат обработан заново.
+	CacheMiss CacheStatus = "miss"
+)
+
+// Result результат обработки изображения.
+type Result struct {
+	Data        []byte
+	ContentType string
+	// Quality итоговое качество JPEG, 0 для других форматов.
+	Quality int
+	// CacheStatus результат поиска в кэше вариантов, OriginalCacheStatus -
+	// в кэше оригиналов. OriginalCacheStatus пустой, если вариант взят из кэша.
+	CacheStatus         CacheStatus
+	OriginalCacheStatus CacheStatus
+	// OriginalWidth и OriginalHeight размеры исходного изображения после
+	// применения ориентации, 0 для вариантов, сохраненных без размеров.
+	OriginalWidth  int
+	OriginalHeight int
+	// Timings длительности стадий обработки в порядке выполнения.
+	Timings []Timing
+	// ETag сильный валидатор результата, LastModified - время обработки.
+	// Пустые для вариантов, сохраненных в кэше без валидаторов.
+	ETag         string
+	LastModified time.Time
+	// Size размер изображения в байтах. У результатов StreamImage и
+	// VariantInfo Data пустой, есть только размер.
+	Size int
+	// Body данные изображения, которые StreamImage возвращает потоком
+	// из хранилища. Закрывает вызывающий.
+	Body io.ReadSeekCloser
+}
+
+type missGuardKey struct{}
+
+// WithMissGuard возвращает контекст, в котором обработчик вызывает guard
+// перед загрузкой и обработкой изображения, которого нет в кэше. Ошибка
+// guard прерывает обработку и возвращается без изменений.
+func WithMissGuard(ctx context.Context, guard func() error) context.Context {
+	return context.WithValue(ctx, missGuardKey{}, guard)
+}
+
+func checkMissGuard(ctx context.Context) error {
+	if guard, ok := ctx.Value(missGuardKey{}).(func() error); ok {
+		return guard()
+	}
+	return nil
+}
+
 // ImageProcessor обработчик изображений.
 type ImageProcessor struct {
-	cache  *cache.LRUCache
-	client *http.Client
+	cache    *cache.LRUCache
+	variants *cache.LRUCache
+	config   atomic.Pointer[Config]
+	client   *http.Client
+	pool     *workpool.Pool
+	memory   *membudget.Budget
 }
 
-func NewImageProcessor(cache *cache.LRUCache) *ImageProcessor {
-	return &ImageProcessor{
-		cache:  cache,
-		client: &http.Client{Timeout: 30 * time.Second},
+// NewImageProcessor создает обработчик. Кэш вариантов может быть nil,
+// тогда результаты обработки не кэшируются.
+func NewImageProcessor(cache, variants *cache.LRUCache, config Config) *ImageProcessor {
+	p := &ImageProcessor{
+		cache:    cache,
+		variants: variants,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		pool:     workpool.New(config.RenderWorkers, config.RenderQueueDepth, config.RenderQueueTimeout),
+		memory:   membudget.New(config.MemoryBudget, config.MemoryBudgetTimeout),
 	}
+	p.config.Store(&config)
+	return p
 }
 
-func (p *ImageProcessor) GetOriginalImage(ctx context.Context, url string) (image.Image, error) {
+// UpdateConfig проверяет и атомарно заменяет конфигурацию. Размеры пула
+// обработки и бюджета памяти задаются при создании и не меняются.
+func (p *ImageProcessor) UpdateConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	p.config.Store(&config)
+	return nil
+}
+
+// currentConfig возвращает действующую конфигурацию.
+func (p *ImageProcessor) currentConfig() *Config {
+	return p.config.Load()
+}
+
+// PoolStats возвращает состояние пула обработки.
+func (p *ImageProcessor) PoolStats() workpool.Stats {
+	return p.pool.Stats()
+}
+
+// MemoryStats возвращает состояние бюджета памяти.
+func (p *ImageProcessor) MemoryStats() membudget.Stats {
+	return p.memory.Stats()
+}
+
+// GetOriginalData возвращает исходные байты изображения из кэша или источника.
+// В кэш оригиналов изображение попадает без перекодирования, вместе с метаданными.
+func (p *ImageProcessor) GetOriginalData(ctx context.Context, url string) ([]byte, error) {
+	data, _, err := p.originalData(ctx, url)
+	return data, err
+}
+
+// originalData возвращает исходные байты изображения и результат поиска
+// в кэше оригиналов.
+func (p *ImageProcessor) originalData(ctx context.Context, url string) ([]byte, CacheStatus, error) {
 	// Ключ кэша - только URL без размеров
 	cacheKey := url
 
 	// Пытаемся получить из кэша
+	start := time.Now()
 	cachedData, err := p.cache.Get(ctx, cacheKey)
 	if err == nil {
 		defer cachedData.Close()
-		img, _, err := image.Decode(cachedData)
+		data, err := io.ReadAll(cachedData)
+		recordTiming(ctx, TimingCache, start)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode cached image: %w", err)
+			return nil, "", fmt.Errorf("failed to read cached image: %w", err)
 		}
-		return img, nil
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return nil, fmt.Errorf("failed to get from cache: %w", err)
+		return data, CacheHit, nil
+	}
+	recordTiming(ctx, TimingCache, start)
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to get from cache: %w", err)
 	}
 
 	// Если в кэше нет, скачиваем изображение
+	data, err := p.fetch(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Не кэшируем то, что не является изображением
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	// Сохраняем оригинал в кэш
+	start = time.Now()
+	err = p.cache.Set(ctx, cacheKey, data)
+	recordTiming(ctx, TimingCache, start)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to cache image: %w", err)
+	}
+
+	return data, CacheMiss, nil
+}
+
+// fetch загружает изображение с источника.
+func (p *ImageProcessor) fetch(ctx context.Context, url string) ([]byte, error) {
+	host, _, _ := strings.Cut(url, "/")
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", host)))
+	defer span.End()
+
+	start := time.Now()
+	data, err := p.download(ctx, url)
+	label := p.originLabel(host)
+	metrics.OriginFetchDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	recordTiming(ctx, TimingFetch, start)
+	if err != nil {
+		metrics.OriginFetchErrors.WithLabelValues(label).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "origin fetch failed")
+		slog.WarnContext(ctx, "Origin fetch failed", "host", host, "error", err)
+		return nil, err
+	}
+	metrics.OriginBytes.Add(float64(len(data)))
+	span.SetAttributes(attribute.Int("http.response.body.size", len(data)))
+	return data, nil
+}
+
+// download выполняет запрос к источнику и читает ответ.
+func (p *ImageProcessor) download(ctx context.Context, url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	// Источник получает контекст трассировки запроса
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := p.client.Do(req)
 	if err != nil {
@@ -61,40 +417,523 @@ func (p *ImageProcessor) GetOriginalImage(ctx context.Context, url string) (imag
 		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
 	}
 
-	// Декодируем изображение
-	img, _, err := image.Decode(resp.Body)
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
+		return nil, fmt.Errorf("failed to download image: %w", err)
 	}
+	return data, nil
+}
 
-	// Сохраняем оригинал в кэш
-	var buf bytes.Buffer
-	if err := imaging.Encode(&buf, img, imaging.JPEG); err != nil {
-		return nil, fmt.Errorf("failed to encode image for cache: %w", err)
+// GetOriginalImage возвращает декодированное исходное изображение.
+// Ориентация из EXIF применяется, если это включено в Config.
+func (p *ImageProcessor) GetOriginalImage(ctx context.Context, url string) (image.Image, error) {
+	data, err := p.GetOriginalData(ctx, url)
+	if err != nil {
+		return nil, err
 	}
+	img, _, err := decodeOriginal(data, p.currentConfig().AutoOrient)
+	return img, err
+}
 
-	if err := p.cache.Set(ctx, cacheKey, buf.Bytes()); err != nil {
-		return nil, fmt.Errorf("failed to cache image: %w", err)
+// decodeOriginal декодирует изображение и при autoOrient поворачивает его
+// согласно тегу EXIF Orientation.
+func decodeOriginal(data []byte, autoOrient bool) (image.Image, exifData, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, exifData{}, fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	return img, nil
+	var ex exifData
+	if format == "jpeg" {
+		ex = parseJPEGExif(data)
+	}
+	if autoOrient {
+		img = applyOrientation(img, ex.Orientation)
+	}
+	return img, ex, nil
+}
+
+// OtherOrigin метка метрик для источников не из Config.MetricsOrigins.
+const OtherOrigin = "other"
+
+// originLabel возвращает метку метрик для источника host.
+func (p *ImageProcessor) originLabel(host string) string {
+	if slices.Contains(p.currentConfig().MetricsOrigins, host) {
+		return host
+	}
+	return OtherOrigin
+}
+
+// keepCopyright сообщает, нужно ли сохранять авторские поля EXIF
+// для изображений с этого источника.
+func (p *ImageProcessor) keepCopyright(url string) bool {
+	host, _, _ := strings.Cut(url, "/")
+	return slices.Contains(p.currentConfig().KeepCopyrightOrigins, host)
 }
 
-func (p *ImageProcessor) ProcessImage(ctx context.Context, url string, width, height int) ([]byte, string, error) {
+// normalize подставляет значения по умолчанию и проверяет границы.
+func (p *ImageProcessor) normalize(opts Options) (Options, error) {
+	opts.Operations = slices.Clone(opts.Operations)
+	hasFill, hasFreeRotate := false, false
+	for _, op := range opts.Operations {
+		if err := op.validate(); err != nil {
+			return opts, err
+		}
+		switch op := op.(type) {
+		case Resize:
+			hasFill = hasFill || op.Type == ResizeFill
+		case Rotate:
+			hasFreeRotate = hasFreeRotate || !op.rightAngle()
+		}
+	}
+
+	// Настройки, которые не влияют на результат, сбрасываются, чтобы
+	// одинаковые варианты имели одинаковый ключ
+	if !hasFill {
+		opts.Gravity = ""
+	} else if opts.Gravity == "" {
+		opts.Gravity = "ce"
+	}
+	if _, ok := gravities[opts.Gravity]; opts.Gravity != "" && !ok {
+		return opts, fmt.Errorf("%w: unknown gravity %q", ErrInvalidOptions, opts.Gravity)
+	}
+
+	if !hasFreeRotate {
+		opts.Background = ""
+	}
+	if _, err := parseColor(opts.Background); err != nil {
+		return opts, err
+	}
+
+	switch opts.Format {
+	case "", FormatJPEG, FormatPNG, FormatGIF:
+	default:
+		return opts, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, opts.Format)
+	}
+	mayBeJPEG := opts.Format == "" || opts.Format == FormatJPEG
+	mayBeAnimated := opts.Format == "" || opts.Format == FormatGIF
+
+	if !mayBeJPEG {
+		opts.Quality = 0
+		opts.Progressive = false
+	} else {
+		if opts.Quality == 0 {
+			opts.Quality = p.currentConfig().DefaultQuality
+		}
+		if opts.Quality < p.currentConfig().MinQuality || opts.Quality > p.currentConfig().MaxQuality {
+			return opts, fmt.Errorf("%w: quality %d is out of bounds %d..%d",
+				ErrInvalidOptions, opts.Quality, p.currentConfig().MinQuality, p.currentConfig().MaxQuality)
+		}
+	}
+
+	if opts.Format != FormatPNG {
+		opts.PNGCompression = ""
+	} else {
+		if opts.PNGCompression == "" {
+			opts.PNGCompression = p.currentConfig().PNGCompression
+		}
+		if _, ok := pngCompressionLevels[opts.PNGCompression]; !ok {
+			return opts, fmt.Errorf("%w: unknown png compression %q", ErrInvalidOptions, opts.PNGCompression)
+		}
+	}
+
+	if opts.Filter == "" {
+		opts.Filter = p.currentConfig().DefaultFilter
+	}
+	if _, ok := resampleFilters[opts.Filter]; !ok {
+		return opts, fmt.Errorf("%w: unknown filter %q", ErrInvalidOptions, opts.Filter)
+	}
+
+	if opts.AutoOrient == nil {
+		autoOrient := p.currentConfig().AutoOrient
+		opts.AutoOrient = &autoOrient
+	}
+
+	if opts.MaxFrames < 0 {
+		return opts, fmt.Errorf("%w: negative frames %d", ErrInvalidOptions, opts.MaxFrames)
+	}
+	if !mayBeAnimated {
+		opts.MaxFrames = 0
+	} else if opts.MaxFrames == 0 || opts.MaxFrames > p.currentConfig().MaxFrames {
+		opts.MaxFrames = p.currentConfig().MaxFrames
+	}
+
+	if opts.MaxBytes < 0 {
+		return opts, fmt.Errorf("%w: negative maxbytes %d", ErrInvalidOptions, opts.MaxBytes)
+	}
+
+	return opts, nil
+}
+
+// ProcessImage обрабатывает изображение и возвращает результат целиком
+// в Result.Data.
+func (p *ImageProcessor) ProcessImage(ctx context.Context, url string, opts Options) (*Result, error) {
+	result, err := p.StreamImage(ctx, url, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Body.Close()
+	result.Data, err = io.ReadAll(result.Body)
+	result.Body = nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached variant: %w", err)
+	}
+	return result, nil
+}
+
+// ResponseFunc получает метаданные нового варианта перед кодированием и
+// возвращает писатель, в который вариант кодируется одновременно с записью
+// в кэш вариантов.
+type ResponseFunc func(*Result) io.Writer
+
+// StreamImage обрабатывает изображение, не собирая результат в памяти
+// целиком. Вариант из кэша возвращается в Result.Body потоком из хранилища.
+// Новый вариант кодируется одновременно в кэш вариантов и в писатель,
+// который вернул respond, и Body у результата пустой. Пока клиент
+// принимает данные, он занимает исполнитель пула и бюджет памяти. Если
+// respond nil или вариант нельзя кодировать потоком, Body читает результат
+// из памяти. Ошибка кэша не прерывает обработку и только выводится в журнал.
+func (p *ImageProcessor) StreamImage(ctx context.Context, url string, opts Options, respond ResponseFunc,
+) (*Result, error) {
+	opts, err := p.normalize(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, timings := withTimings(ctx)
+
+	fingerprint := p.configFingerprint(url, opts)
+	variantKey := variantKey(url, opts, fingerprint)
+	if p.variants != nil {
+		result, err := p.openVariant(ctx, variantKey)
+		if err == nil {
+			result.Timings = timings.get()
+			return result, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := checkMissGuard(ctx); err != nil {
+		return nil, err
+	}
+
 	// Получаем оригинальное изображение (из кэша или скачиваем)
-	img, err := p.GetOriginalImage(ctx, url)
+	original, originalStatus, err := p.originalData(ctx, url)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+
+	// Память под обработку резервируется до декодирования
+	size, err := estimateMemory(original, opts, p.currentConfig().MaxOutputPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Обработка ограничена пулом, варианты из кэша отдаются без очереди.
+	// Память занимается только получившей исполнителя задачей
+	var result *Result
+	var streamed bool
+	poolErr := p.pool.Do(ctx, func() {
+		var release func()
+		release, err = p.memory.Acquire(ctx, size)
+		if err != nil {
+			return
+		}
+		defer release()
+		var encode func(io.Writer) error
+		result, encode, err = p.render(ctx, url, original, opts)
+		if err != nil {
+			return
+		}
+		result.ETag = variantETag(original, opts, fingerprint)
+		result.LastModified = time.Now().UTC().Truncate(time.Second)
+		result.CacheStatus = CacheMiss
+		result.OriginalCacheStatus = originalStatus
+
+		switch {
+		case encode == nil:
+			// Результат уже закодирован в память, остается сохранить его
+			data := result.Data
+			_, err = p.storeVariant(ctx, variantKey, result, func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}, io.Discard)
+		case respond == nil:
+			var buf bytes.Buffer
+			_, err = p.storeVariant(ctx, variantKey, result, encode, &buf)
+			result.Data = buf.Bytes()
+		default:
+			result.Timings = timings.get()
+			streamed = true
+			result.Size, err = p.storeVariant(ctx, variantKey, result, encode, respond(result))
+		}
+	})
+	if poolErr != nil {
+		return nil, poolErr
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Масштабируем изображение с использованием библиотеки imaging
-	resizedImg := imaging.Resize(img, width, height, imaging.Lanczos)
+	if !streamed {
+		result.Size = len(result.Data)
+		result.Body = nopCloser{bytes.NewReader(result.Data)}
+		result.Data = nil
+	}
+	result.Timings = timings.get()
+	return result, nil
+}
+
+// storeVariant кодирует результат функцией encode в out и одновременно
+// в новую запись кэша вариантов. Возвращает число байт, записанных в out.
+// Ошибка кэша не прерывает кодирование и только выводится в журнал,
+// ошибка out не прерывает запись в кэш.
+func (p *ImageProcessor) storeVariant(ctx context.Context, key string, result *Result, encode func(io.Writer) error,
+	out io.Writer,
+) (int, error) {
+	tee := &teeWriter{out: out}
+	if p.variants != nil {
+		cached, err := p.variants.Create(ctx, key)
+		if err == nil {
+			defer cached.Abort()
+			if err = writeVariantMeta(cached, newVariantMeta(result)); err == nil {
+				tee.cache = cached
+			}
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to cache variant", "error", err)
+		}
+	}
 
-	// Кодируем в JPEG
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, resizedImg, &jpeg.Options{Quality: 85}); err != nil {
-		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	if err := encode(tee); err != nil {
+		return tee.n, err
 	}
+	if tee.cacheErr != nil {
+		slog.WarnContext(ctx, "Failed to cache variant", "error", tee.cacheErr)
+	} else if tee.cache != nil {
+		begin := time.Now()
+		err := tee.cache.Commit()
+		recordTiming(ctx, TimingCache, begin)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to cache variant", "error", err)
+		}
+	}
+	return tee.n, tee.outErr
+}
 
-	return buf.Bytes(), "image/jpeg", nil
+// teeWriter пишет вариант в кэш и в out. Ошибка одного из них не прерывает
+// запись в другой, кодирование останавливается, только когда писать
+// больше некуда.
+type teeWriter struct {
+	cache    storage.Writer
+	out      io.Writer
+	cacheErr error
+	outErr   error
+	n        int
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	if w.cache != nil && w.cacheErr == nil {
+		if _, err := w.cache.Write(b); err != nil {
+			w.cacheErr = err
+		}
+	}
+	if w.outErr == nil {
+		if _, err := w.out.Write(b); err != nil {
+			w.outErr = err
+		} else {
+			w.n += len(b)
+		}
+	}
+	if w.outErr != nil && (w.cache == nil || w.cacheErr != nil) {
+		return 0, w.outErr
+	}
+	return len(b), nil
+}
+
+// nopCloser данные результата в памяти для Result.Body.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// openVariant открывает вариант в кэше вариантов. Данные изображения не
+// читаются в память, а возвращаются в Result.Body. Если варианта нет в
+// кэше, возвращает ошибку os.ErrNotExist.
+func (p *ImageProcessor) openVariant(ctx context.Context, key string) (*Result, error) {
+	start := time.Now()
+	defer recordTiming(ctx, TimingCache, start)
+
+	record, err := p.variants.Open(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get variant from cache: %w", err)
+	}
+	meta, offset, err := readVariantMeta(record)
+	if err != nil {
+		record.Close()
+		return nil, fmt.Errorf("failed to decode cached variant: %w", err)
+	}
+	result := meta.result()
+	result.Size = int(record.Size() - offset)
+	result.Body = variantBody{SectionReader: io.NewSectionReader(record, offset, record.Size()-offset), Closer: record}
+	return result, nil
+}
+
+// VariantInfo возвращает сведения о варианте из кэша вариантов без данных
+// изображения, не загружая и не обрабатывая его. ok равен false, если
+// варианта нет в кэше.
+func (p *ImageProcessor) VariantInfo(ctx context.Context, url string, opts Options) (*Result, bool) {
+	if p.variants == nil {
+		return nil, false
+	}
+	opts, err := p.normalize(opts)
+	if err != nil {
+		return nil, false
+	}
+	result, err := p.openVariant(ctx, variantKey(url, opts, p.configFingerprint(url, opts)))
+	if err != nil {
+		return nil, false
+	}
+	result.Body.Close()
+	result.Body = nil
+	return result, true
+}
+
+// variantKey возвращает ключ варианта в кэше: URL вместе с каноническими
+// параметрами обработки и отпечатком настроек.
+func variantKey(url string, opts Options, fingerprint string) string {
+	return url + "#" + opts.Key() + "/" + configOption + ":" + fingerprint
+}
+
+// configOption имя отпечатка настроек в ключе варианта.
+const configOption = "cfg"
+
+// configFingerprint возвращает отпечаток настроек, которые влияют на
+// результат обработки url с параметрами opts, но не входят в параметры.
+// Настройки меняются при перезагрузке конфигурации, и отпечаток не дает
+// отдавать из кэша варианты, обработанные по прежним настройкам.
+func (p *ImageProcessor) configFingerprint(url string, opts Options) string {
+	config := p.currentConfig()
+	h := sha256.New()
+	fmt.Fprintf(h, "copyright:%t\n", p.keepCopyright(url))
+	if resampleFilters[opts.Filter].Support > expensiveFilterSupport {
+		fmt.Fprintf(h, "fallback:%s:%d\n", config.FallbackFilter, config.ExpensiveFilterMaxPixels)
+	}
+	if opts.MaxBytes > 0 {
+		fmt.Fprintf(h, "maxbytes:%s\n", config.MaxBytesPolicy)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// variantETag возвращает сильный ETag варианта: хэш содержимого исходного
+// изображения, которое служит его валидатором, канонических параметров и
+// отпечатка настроек.
+func variantETag(original []byte, opts Options, fingerprint string) string {
+	source := sha256.Sum256(original)
+	h := sha256.New()
+	h.Write(source[:])
+	h.Write([]byte(opts.Key()))
+	h.Write([]byte(fingerprint))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// render декодирует исходное изображение и обрабатывает его. Если результат
+// можно кодировать потоком, возвращает функцию, которая кодирует его в w,
+// иначе кодирует его в Result.Data.
+func (p *ImageProcessor) render(ctx context.Context, url string, original []byte, opts Options,
+) (*Result, func(w io.Writer) error, error) {
+	end := startStage(ctx, stageDecode)
+	if g := decodeAnimation(original, opts); g != nil {
+		end()
+		result, err := p.renderAnimation(ctx, g, opts)
+		return result, nil, err
+	}
+	if opts.Format == "" {
+		opts.Format = FormatJPEG
+	}
+
+	img, ex, err := decodeOriginal(original, *opts.AutoOrient)
+	end()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end = startStage(ctx, stageTransform)
+	env := p.newStepEnv(img, opts)
+	transformed := transform(img, opts, env)
+	end()
+
+	keepCopyright := p.keepCopyright(url)
+	result := &Result{
+		ContentType:    opts.ContentType(),
+		OriginalWidth:  img.Bounds().Dx(),
+		OriginalHeight: img.Bounds().Dy(),
+	}
+	if opts.MaxBytes == 0 && !keepCopyright {
+		// Размер не ограничен и метаданные не добавляются, поэтому
+		// изображение кодируется сразу в получателя
+		if opts.Format == FormatJPEG {
+			result.Quality = opts.Quality
+		}
+		return result, func(w io.Writer) error {
+			defer startStage(ctx, stageEncode)()
+			if err := encode(w, transformed, opts); err != nil {
+				return fmt.Errorf("failed to encode image: %w", err)
+			}
+			return nil
+		}, nil
+	}
+
+	end = startStage(ctx, stageEncode)
+	defer end()
+	if opts.MaxBytes > 0 {
+		// Сохраняемые метаданные тоже должны уложиться в бюджет
+		budget := opts
+		if keepCopyright {
+			block, _ := copyrightMetadata(opts.Format, ex)
+			budget.MaxBytes -= len(block)
+		}
+		result.Data, result.Quality, err = p.encodeWithinBudget(transformed, budget, env.filter)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := encode(&buf, transformed, opts); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		result.Data = buf.Bytes()
+		if opts.Format == FormatJPEG {
+			result.Quality = opts.Quality
+		}
+	}
+
+	if keepCopyright {
+		result.Data = withCopyright(result.Data, opts.Format, ex)
+	}
+	return result, nil, nil
+}
+
+// newStepEnv собирает общие параметры шагов. Фильтр выбирается
+// по размеру исходного изображения.
+func (p *ImageProcessor) newStepEnv(img image.Image, opts Options) *stepEnv {
+	background, _ := parseColor(opts.Background)
+	return &stepEnv{
+		filter:     p.resampleFilter(img, opts.Filter),
+		gravity:    gravities[opts.Gravity],
+		background: background,
+	}
+}
+
+// transform выполняет операции конвейера над изображением или кадром анимации.
+func transform(img image.Image, opts Options, env *stepEnv) image.Image {
+	for _, op := range opts.Operations {
+		img = op.apply(img, env)
+	}
+	return img
 }
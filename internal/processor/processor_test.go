@@ -0,0 +1,664 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"imageproxy/internal/cache"
+	"imageproxy/internal/metrics"
+	"imageproxy/internal/storage"
+	"imageproxy/internal/workpool"
+)
+
+// testImage возвращает градиент, чтобы качество влияло на размер JPEG.
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x ^ y) % 256), A: 255})
+		}
+	}
+	return img
+}
+
+// newTestOrigin поднимает HTTP-сервер с одним JPEG и возвращает URL без схемы.
+func newTestOrigin(t *testing.T, img image.Image) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	return newTestOriginData(t, buf.Bytes())
+}
+
+// newTestOriginData поднимает HTTP-сервер, отдающий data как есть.
+func newTestOriginData(t *testing.T, data []byte) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://") + "/image.jpg"
+}
+
+// resize возвращает конвейер из растягивающего масштабирования и ops.
+func resize(width, height int, ops ...Operation) []Operation {
+	return append([]Operation{Resize{Type: ResizeForce, Width: width, Height: height}}, ops...)
+}
+
+func newTestProcessor(config Config) *ImageProcessor {
+	store := storage.NewMemoryStorage()
+	return NewImageProcessor(cache.NewLRUCache("originals", 10, storage.WithPrefix(store, "originals/")),
+		cache.NewLRUCache("variants", 10, storage.WithPrefix(store, "variants/")), config)
+}
+
+func TestProcessImage_Quality(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(256, 256))
+	p := newTestProcessor(DefaultConfig())
+
+	low, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), Quality: 30})
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", low.ContentType)
+	assert.Equal(t, 30, low.Quality)
+
+	high, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), Quality: 95})
+	require.NoError(t, err)
+	assert.Less(t, len(low.Data), len(high.Data))
+}
+
+func TestProcessImage_QualityBounds(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	config.MinQuality = 40
+	config.MaxQuality = 90
+	p := newTestProcessor(config)
+
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Quality: 95})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Quality: 10})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Format: "webp"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestProcessImage_Progressive(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(123, 77))
+	p := newTestProcessor(DefaultConfig())
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(101, 61), Progressive: true})
+	require.NoError(t, err)
+	// SOF2 обозначает прогрессивный JPEG
+	assert.True(t, bytes.Contains(result.Data, []byte{0xff, 0xc2}))
+
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 101, 61), img.Bounds())
+
+	// Сравниваем с baseline-кодированием того же варианта
+	baseline, err := p.ProcessImage(ctx, url, Options{Operations: resize(101, 61)})
+	require.NoError(t, err)
+	reference, err := jpeg.Decode(bytes.NewReader(baseline.Data))
+	require.NoError(t, err)
+
+	r1, g1, b1, _ := img.At(50, 30).RGBA()
+	r2, g2, b2, _ := reference.At(50, 30).RGBA()
+	assert.InDelta(t, r2>>8, r1>>8, 12)
+	assert.InDelta(t, g2>>8, g1>>8, 12)
+	assert.InDelta(t, b2>>8, b1>>8, 12)
+}
+
+func TestProcessImage_PNG(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Format: FormatPNG, PNGCompression: "best"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", result.ContentType)
+
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Format: FormatPNG, PNGCompression: "ultra"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestProcessImage_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(256, 256))
+	p := newTestProcessor(DefaultConfig())
+
+	unlimited, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200)})
+	require.NoError(t, err)
+
+	budget := len(unlimited.Data) / 2
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), MaxBytes: budget})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(result.Data), budget)
+	assert.Less(t, result.Quality, unlimited.Quality)
+	assert.GreaterOrEqual(t, result.Quality, 1)
+
+	// Из кэша вариантов возвращается то же качество
+	cached, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), MaxBytes: budget})
+	require.NoError(t, err)
+	assert.Equal(t, result.Quality, cached.Quality)
+	assert.Equal(t, result.Data, cached.Data)
+}
+
+func TestProcessImage_MaxBytesPolicy(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(256, 256))
+
+	p := newTestProcessor(DefaultConfig())
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), MaxBytes: 1000})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	config := DefaultConfig()
+	config.MaxBytesPolicy = MaxBytesDownscale
+	p = newTestProcessor(config)
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(200, 200), MaxBytes: 1000})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(result.Data), 1000)
+
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Less(t, img.Bounds().Dx(), 200)
+}
+
+func TestOptions_Key(t *testing.T) {
+	p := newTestProcessor(DefaultConfig())
+
+	explicit, err := p.normalize(Options{Operations: resize(10, 20), Quality: 85})
+	require.NoError(t, err)
+	implicit, err := p.normalize(Options{Operations: resize(10, 20)})
+	require.NoError(t, err)
+	assert.Equal(t, explicit.Key(), implicit.Key())
+
+	progressive, err := p.normalize(Options{Operations: resize(10, 20), Progressive: true})
+	require.NoError(t, err)
+	assert.NotEqual(t, implicit.Key(), progressive.Key())
+
+	other, err := p.normalize(Options{Operations: resize(10, 20), Quality: 60})
+	require.NoError(t, err)
+	assert.NotEqual(t, implicit.Key(), other.Key())
+}
+
+func TestProcessImage_Filter(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+
+	nearest, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Filter: "nearest"})
+	require.NoError(t, err)
+	lanczos, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	assert.NotEqual(t, nearest.Data, lanczos.Data)
+
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32), Filter: "bicubic"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestResampleFilter_Threshold(t *testing.T) {
+	config := DefaultConfig()
+	config.ExpensiveFilterMaxPixels = 100 * 100
+	p := newTestProcessor(config)
+
+	small := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	large := image.NewRGBA(image.Rect(0, 0, 200, 200))
+
+	assert.Equal(t, 3.0, p.resampleFilter(small, "lanczos").Support)
+	assert.Equal(t, 1.0, p.resampleFilter(large, "lanczos").Support)
+	assert.Equal(t, 2.0, p.resampleFilter(large, "catmullrom").Support)
+}
+
+func TestProcessImage_Adjustments(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32, Grayscale{}), Format: FormatPNG})
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	r, g, b, _ := img.At(20, 10).RGBA()
+	assert.Equal(t, r, g)
+	assert.Equal(t, g, b)
+
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32, Brightness(-100)), Format: FormatPNG})
+	require.NoError(t, err)
+	img, err = png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	r, g, b, _ = img.At(20, 10).RGBA()
+	assert.Zero(t, r+g+b)
+
+	for _, op := range []Operation{Brightness(150), Contrast(-101), Gamma(20), Blur(100), Sharpen(-1)} {
+		_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32, op)})
+		assert.ErrorIs(t, err, ErrInvalidOptions, op)
+	}
+
+	blurred, err := p.normalize(Options{Operations: resize(32, 32, Blur(1.5))})
+	require.NoError(t, err)
+	sharpened, err := p.normalize(Options{Operations: resize(32, 32, Sharpen(1.5))})
+	require.NoError(t, err)
+	assert.NotEqual(t, blurred.Key(), sharpened.Key())
+}
+
+func TestProcessImage_MissGuard(t *testing.T) {
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+	errLimited := errors.New("limited")
+
+	misses := 0
+	ctx := WithMissGuard(context.Background(), func() error {
+		misses++
+		return nil
+	})
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, misses)
+
+	// Отказ guard не мешает отдавать варианты из кэша
+	ctx = WithMissGuard(context.Background(), func() error { return errLimited })
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+	assert.ErrorIs(t, err, errLimited)
+}
+
+func TestProcessImage_RenderPool(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	config.RenderWorkers = 1
+	config.RenderQueueDepth = 0
+	p := newTestProcessor(config)
+
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+
+	// Единственный исполнитель занят
+	release, started := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = p.pool.Do(ctx, func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	defer close(release)
+
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err, "cache hits must bypass the pool")
+	_, err = p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+	assert.ErrorIs(t, err, workpool.ErrQueueFull)
+	assert.Equal(t, uint64(1), p.PoolStats().Rejected)
+}
+
+func TestUpdateConfig(t *testing.T) {
+	p := newTestProcessor(DefaultConfig())
+	_, ok := p.Preset("thumb")
+	assert.False(t, ok)
+
+	config := DefaultConfig()
+	thumb, err := ParsePreset("thumb", "rs:fill:150:150")
+	require.NoError(t, err)
+	config.Presets = map[string]Options{"thumb": thumb}
+	require.NoError(t, p.UpdateConfig(config))
+	_, ok = p.Preset("thumb")
+	assert.True(t, ok)
+
+	// Неверная конфигурация не применяется
+	config.Presets = nil
+	config.DefaultQuality = 0
+	assert.Error(t, p.UpdateConfig(config))
+	_, ok = p.Preset("thumb")
+	assert.True(t, ok)
+}
+
+func TestProcessImage_ConfigFingerprint(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	p := newTestProcessor(config)
+	opts := Options{Operations: resize(32, 32), MaxBytes: 1 << 20}
+
+	first, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+
+	// Настройки, которые не влияют на результат, не меняют вариант
+	config.MetricsOrigins = []string{"example.com"}
+	require.NoError(t, p.UpdateConfig(config))
+	cached, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	assert.Equal(t, CacheHit, cached.CacheStatus)
+	assert.Equal(t, first.ETag, cached.ETag)
+
+	// Вариант, обработанный по прежним настройкам, не отдается
+	config.MaxBytesPolicy = MaxBytesDownscale
+	require.NoError(t, p.UpdateConfig(config))
+	policy, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	assert.Equal(t, CacheMiss, policy.CacheStatus)
+	assert.NotEqual(t, first.ETag, policy.ETag)
+
+	config.KeepCopyrightOrigins = []string{strings.Split(url, "/")[0]}
+	require.NoError(t, p.UpdateConfig(config))
+	copyright, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	assert.Equal(t, CacheMiss, copyright.CacheStatus)
+	assert.NotEqual(t, policy.ETag, copyright.ETag)
+
+	// В записях кэша отпечаток не показывается среди параметров
+	entries, err := p.CacheEntries(ctx)
+	require.NoError(t, err)
+	var variants []string
+	for _, entry := range entries {
+		if entry.Kind == EntryVariant {
+			variants = append(variants, entry.Options)
+		}
+	}
+	require.Len(t, variants, 3)
+	assert.Equal(t, variants[0], variants[1])
+	assert.NotContains(t, variants[0], configOption+":")
+}
+
+func TestProcessImage_OriginMetrics(t *testing.T) {
+	ctx := context.Background()
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Второй запрос к источнику завершается ошибкой
+		if requests++; requests > 1 {
+			http.NotFound(w, r)
+			return
+		}
+		_ = jpeg.Encode(w, testImage(64, 64), nil)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	config := DefaultConfig()
+	config.MetricsOrigins = []string{host}
+	p := newTestProcessor(config)
+
+	_, err := p.ProcessImage(ctx, host+"/image.jpg", Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	_, err = p.ProcessImage(ctx, host+"/missing.jpg", Options{Operations: resize(32, 32)})
+	require.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `imageproxy_origin_fetch_duration_seconds_count{host="`+host+`"} 2`)
+	assert.Contains(t, body, `imageproxy_origin_fetch_errors_total{host="`+host+`"} 1`)
+	assert.Contains(t, body, `imageproxy_processing_stage_duration_seconds_count{stage="encode"}`)
+}
+
+func TestProcessImage_OriginMetricsBounded(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	_, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	p := newTestProcessor(DefaultConfig())
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	otherErrors := func(body string) int {
+		for _, line := range strings.Split(body, "\n") {
+			if value, ok := strings.CutPrefix(line, `imageproxy_origin_fetch_errors_total{host="other"} `); ok {
+				n, err := strconv.Atoi(value)
+				require.NoError(t, err)
+				return n
+			}
+		}
+		return 0
+	}
+
+	// Источники не из списка не создают новых рядов
+	before := otherErrors(scrape())
+	hosts := []string{"127.0.0.1:" + port, "localhost:" + port, "127.0.0.2:" + port}
+	for _, host := range hosts {
+		_, err := p.ProcessImage(ctx, host+"/image.jpg", Options{Operations: resize(32, 32)})
+		require.Error(t, err)
+	}
+	body := scrape()
+	for _, host := range hosts {
+		assert.NotContains(t, body, `host="`+host+`"`)
+	}
+	assert.Equal(t, before+len(hosts), otherErrors(body))
+}
+
+func TestProcessImage_CacheStatus(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+
+	stages := func(result *Result) []string {
+		var names []string
+		for _, timing := range result.Timings {
+			names = append(names, timing.Stage)
+		}
+		return names
+	}
+
+	result, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	assert.Equal(t, CacheMiss, result.CacheStatus)
+	assert.Equal(t, CacheMiss, result.OriginalCacheStatus)
+	assert.Equal(t, []int{64, 64}, []int{result.OriginalWidth, result.OriginalHeight})
+	assert.Equal(t, []string{TimingCache, TimingFetch, TimingDecode, TimingTransform, TimingEncode}, stages(result))
+
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+	require.NoError(t, err)
+	assert.Equal(t, CacheMiss, result.CacheStatus)
+	assert.Equal(t, CacheHit, result.OriginalCacheStatus)
+	assert.NotContains(t, stages(result), TimingFetch)
+
+	// Размеры оригинала хранятся вместе с вариантом
+	result, err = p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	assert.Equal(t, CacheHit, result.CacheStatus)
+	assert.Empty(t, result.OriginalCacheStatus)
+	assert.Equal(t, []int{64, 64}, []int{result.OriginalWidth, result.OriginalHeight})
+	assert.Equal(t, []string{TimingCache}, stages(result))
+}
+
+func TestProcessImage_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		_ = jpeg.Encode(w, testImage(64, 64), nil)
+	}))
+	t.Cleanup(server.Close)
+	url := strings.TrimPrefix(server.URL, "http://") + "/image.jpg"
+	p := newTestProcessor(DefaultConfig())
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(32, 32)})
+	require.NoError(t, err)
+	parent.End()
+
+	// Источник получает контекст трассировки запроса
+	traceID := parent.SpanContext().TraceID().String()
+	assert.Contains(t, traceparent, traceID)
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String(), span.Name())
+		names = append(names, span.Name())
+	}
+	for _, name := range []string{"cache.get", "fetch", "storage.write", "decode", "transform", "encode", "cache.set"} {
+		assert.Contains(t, names, name)
+	}
+}
+
+func TestProcessImage_VariantInfo(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+	opts := Options{Operations: resize(32, 32)}
+
+	_, ok := p.VariantInfo(ctx, url, opts)
+	assert.False(t, ok, "variant is not cached yet")
+
+	first, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.ETag)
+	assert.False(t, first.LastModified.IsZero())
+
+	info, ok := p.VariantInfo(ctx, url, opts)
+	require.True(t, ok)
+	assert.Equal(t, first.ETag, info.ETag)
+	assert.True(t, first.LastModified.Equal(info.LastModified))
+	assert.Equal(t, len(first.Data), info.Size)
+	assert.Equal(t, first.ContentType, info.ContentType)
+	assert.Equal(t, CacheHit, info.CacheStatus)
+	assert.Empty(t, info.Data)
+
+	cached, err := p.ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	assert.Equal(t, first.ETag, cached.ETag)
+	assert.Equal(t, len(first.Data), cached.Size)
+
+	// ETag не зависит от кэша, но зависит от параметров обработки
+	other, err := newTestProcessor(DefaultConfig()).ProcessImage(ctx, url, opts)
+	require.NoError(t, err)
+	assert.Equal(t, first.ETag, other.ETag)
+	other, err = p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ETag, other.ETag)
+}
+
+// failingStorage хранилище, в которое нельзя записать.
+type failingStorage struct {
+	storage.Storage
+}
+
+func (failingStorage) Create(context.Context, string) (storage.Writer, error) {
+	return nil, errors.New("disk is full")
+}
+
+func TestStreamImage(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	config := DefaultConfig()
+	config.RenderWorkers = 1
+	config.RenderQueueDepth = 0
+	p := newTestProcessor(config)
+	opts := Options{Operations: resize(32, 32)}
+
+	// Новый вариант кодируется клиенту и в кэш одновременно
+	var out bytes.Buffer
+	result, err := p.StreamImage(ctx, url, opts, func(result *Result) io.Writer {
+		assert.Equal(t, CacheMiss, result.CacheStatus)
+		assert.NotEmpty(t, result.ETag)
+		assert.Equal(t, "image/jpeg", result.ContentType)
+		assert.Equal(t, 1, p.PoolStats().Busy)
+		return &out
+	})
+	require.NoError(t, err)
+	assert.Nil(t, result.Body)
+	assert.Empty(t, result.Data)
+	assert.Equal(t, out.Len(), result.Size)
+	assert.Zero(t, p.PoolStats().Busy)
+	assert.Zero(t, p.MemoryStats().Used)
+
+	cached, err := p.StreamImage(ctx, url, opts, nil)
+	require.NoError(t, err)
+	defer cached.Body.Close()
+	assert.Equal(t, CacheHit, cached.CacheStatus)
+	assert.Equal(t, result.ETag, cached.ETag)
+	cachedData, err := io.ReadAll(cached.Body)
+	require.NoError(t, err)
+	assert.Equal(t, out.Bytes(), cachedData)
+
+	// Без писателя результат читается из памяти, исполнитель и бюджет уже
+	// свободны
+	result, err = p.StreamImage(ctx, url, Options{Operations: resize(16, 16)}, nil)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	assert.Zero(t, p.PoolStats().Busy)
+	data, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Len(t, data, result.Size)
+}
+
+// brokenWriter клиент, разорвавший соединение.
+type brokenWriter struct{}
+
+func (brokenWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestStreamImage_ClientError(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	p := newTestProcessor(DefaultConfig())
+	opts := Options{Operations: resize(32, 32)}
+
+	// Ушедший клиент не мешает сохранить вариант
+	_, err := p.StreamImage(ctx, url, opts, func(*Result) io.Writer { return brokenWriter{} })
+	assert.ErrorContains(t, err, "connection reset")
+
+	cached, err := p.StreamImage(ctx, url, opts, nil)
+	require.NoError(t, err)
+	defer cached.Body.Close()
+	assert.Equal(t, CacheHit, cached.CacheStatus)
+	data, err := io.ReadAll(cached.Body)
+	require.NoError(t, err)
+	_, _, err = image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+}
+
+func TestStreamImage_CacheError(t *testing.T) {
+	ctx := context.Background()
+	url := newTestOrigin(t, testImage(64, 64))
+	store := storage.NewMemoryStorage()
+	p := NewImageProcessor(cache.NewLRUCache("originals", 10, storage.WithPrefix(store, "originals/")),
+		cache.NewLRUCache("variants", 10, failingStorage{storage.WithPrefix(store, "variants/")}), DefaultConfig())
+
+	// Ошибка кэша не прерывает обработку, результат отдается из памяти
+	result, err := p.StreamImage(ctx, url, Options{Operations: resize(32, 32)}, nil)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	assert.Equal(t, CacheMiss, result.CacheStatus)
+	data, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Len(t, data, result.Size)
+	_, _, err = image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	// Клиент получает вариант целиком и при потоковой отдаче
+	var out bytes.Buffer
+	result, err = p.StreamImage(ctx, url, Options{Operations: resize(16, 16)}, func(*Result) io.Writer { return &out })
+	require.NoError(t, err)
+	assert.Equal(t, out.Len(), result.Size)
+	_, _, err = image.Decode(&out)
+	assert.NoError(t, err)
+}
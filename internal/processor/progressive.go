@@ -0,0 +1,435 @@
+package processor
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Прогрессивный JPEG-кодировщик. Стандартный image/jpeg умеет писать только
+// baseline, поэтому здесь реализован минимальный вариант SOF2: без
+// субдискретизации цвета, только spectral selection (без successive
+// approximation) и стандартные таблицы Хаффмана из приложения K.
+
+const blockSize = 64
+
+// unscaledQuant базовые таблицы квантования (K.1) в зигзаг-порядке.
+var unscaledQuant = [2][blockSize]byte{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// unzig переводит индекс зигзаг-порядка в естественный порядок блока.
+var unzig = [blockSize]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+// huffmanSpecs стандартные таблицы (K.3): DC и AC яркости, DC и AC цветности.
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanLUT: старшие 8 бит - длина кода, младшие 24 - сам код.
+type huffmanLUT []uint32
+
+func newHuffmanLUT(s huffmanSpec) huffmanLUT {
+	lut := make(huffmanLUT, 256)
+	code, k := uint32(0), 0
+	for i := 0; i < len(s.count); i++ {
+		nBits := uint32(i+1) << 24
+		for j := byte(0); j < s.count[i]; j++ {
+			lut[s.value[k]] = nBits | code
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return lut
+}
+
+// progressiveScan описывает один скан: компоненты и диапазон коэффициентов.
+type progressiveScan struct {
+	comps  []int
+	ss, se int
+}
+
+type progressiveWriter struct {
+	w     *bufio.Writer
+	err   error
+	bits  uint32
+	nBits uint32
+	luts  [4]huffmanLUT
+}
+
+func (e *progressiveWriter) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+func (e *progressiveWriter) writeByte(b byte) {
+	if e.err != nil {
+		return
+	}
+	e.err = e.w.WriteByte(b)
+}
+
+func (e *progressiveWriter) writeMarkerHeader(marker byte, length int) {
+	e.write([]byte{0xff, marker, byte(length >> 8), byte(length & 0xff)})
+}
+
+// emit пишет nBits младших бит значения bits с учетом byte stuffing.
+func (e *progressiveWriter) emit(bits, nBits uint32) {
+	nBits += e.nBits
+	bits <<= 32 - nBits
+	bits |= e.bits
+	for nBits >= 8 {
+		b := byte(bits >> 24)
+		e.writeByte(b)
+		if b == 0xff {
+			e.writeByte(0x00)
+		}
+		bits <<= 8
+		nBits -= 8
+	}
+	e.bits, e.nBits = bits, nBits
+}
+
+func (e *progressiveWriter) emitHuff(h int, value int32) {
+	x := e.luts[h][value]
+	e.emit(x&(1<<24-1), x>>24)
+}
+
+// emitHuffRLE пишет символ (run, size) и дополнительные биты значения.
+func (e *progressiveWriter) emitHuffRLE(h int, runLength, value int32) {
+	a, b := value, value
+	if a < 0 {
+		a, b = -value, value-1
+	}
+	var nBits uint32
+	for a > 0 {
+		nBits++
+		a >>= 1
+	}
+	e.emitHuff(h, runLength<<4|int32(nBits))
+	if nBits > 0 {
+		e.emit(uint32(b)&(1<<nBits-1), nBits)
+	}
+}
+
+// flush дополняет последний байт скана единицами.
+func (e *progressiveWriter) flush() {
+	if e.nBits > 0 {
+		e.emit(0x7f, 7)
+	}
+	e.bits, e.nBits = 0, 0
+}
+
+// encodeProgressiveJPEG кодирует изображение в прогрессивный JPEG.
+func encodeProgressiveJPEG(w io.Writer, m image.Image, quality int) error {
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return errors.New("jpeg: image is too large or empty")
+	}
+
+	quality = max(1, min(100, quality))
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][blockSize]int32
+	for i := range quant {
+		for j := range quant[i] {
+			x := (int32(unscaledQuant[i][j])*int32(scale) + 50) / 100
+			quant[i][j] = max(1, min(255, x))
+		}
+	}
+
+	nComp := 3
+	if _, ok := m.(*image.Gray); ok {
+		nComp = 1
+	}
+
+	coeffs := quantizeBlocks(m, nComp, &quant)
+
+	e := &progressiveWriter{w: bufio.NewWriter(w)}
+	for i, s := range huffmanSpecs {
+		e.luts[i] = newHuffmanLUT(s)
+	}
+
+	// SOI.
+	e.write([]byte{0xff, 0xd8})
+
+	// DQT.
+	nQuant := min(nComp, 2)
+	e.writeMarkerHeader(0xdb, 2+nQuant*(1+blockSize))
+	for i := 0; i < nQuant; i++ {
+		e.writeByte(byte(i))
+		for _, q := range quant[i] {
+			e.writeByte(byte(q))
+		}
+	}
+
+	// SOF2: все компоненты без субдискретизации.
+	e.writeMarkerHeader(0xc2, 8+3*nComp)
+	e.write([]byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), byte(nComp)})
+	for i := 0; i < nComp; i++ {
+		e.write([]byte{byte(i + 1), 0x11, byte(min(i, 1))})
+	}
+
+	// DHT.
+	nHuff := 2 * nQuant
+	length := 2
+	for _, s := range huffmanSpecs[:nHuff] {
+		length += 1 + 16 + len(s.value)
+	}
+	e.writeMarkerHeader(0xc4, length)
+	for i, s := range huffmanSpecs[:nHuff] {
+		e.writeByte([]byte{0x00, 0x10, 0x01, 0x11}[i])
+		e.write(s.count[:])
+		e.write(s.value)
+	}
+
+	// Сначала DC всех компонент, затем низкие частоты яркости, цветность и
+	// в конце высокие частоты яркости.
+	scans := []progressiveScan{{comps: []int{0}, ss: 0, se: 0}, {comps: []int{0}, ss: 1, se: 5}}
+	if nComp == 3 {
+		scans = []progressiveScan{
+			{comps: []int{0, 1, 2}, ss: 0, se: 0},
+			{comps: []int{0}, ss: 1, se: 5},
+			{comps: []int{1}, ss: 1, se: 63},
+			{comps: []int{2}, ss: 1, se: 63},
+		}
+	}
+	scans = append(scans, progressiveScan{comps: []int{0}, ss: 6, se: 63})
+
+	for _, scan := range scans {
+		e.writeScan(scan, coeffs)
+	}
+
+	// EOI.
+	e.write([]byte{0xff, 0xd9})
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *progressiveWriter) writeScan(scan progressiveScan, coeffs [][][blockSize]int32) {
+	e.writeMarkerHeader(0xda, 6+2*len(scan.comps))
+	e.writeByte(byte(len(scan.comps)))
+	for _, c := range scan.comps {
+		tables := byte(0x00)
+		if c > 0 {
+			tables = 0x11
+		}
+		e.write([]byte{byte(c + 1), tables})
+	}
+	e.write([]byte{byte(scan.ss), byte(scan.se), 0})
+
+	if scan.ss == 0 {
+		// DC-скан: блоки компонент чередуются, значения кодируются разностью.
+		var prevDC [3]int32
+		for i := range coeffs[0] {
+			for _, c := range scan.comps {
+				dc := coeffs[c][i][0]
+				e.emitHuffRLE(2*min(c, 1), 0, dc-prevDC[c])
+				prevDC[c] = dc
+			}
+		}
+	} else {
+		h := 2*min(scan.comps[0], 1) + 1
+		for _, block := range coeffs[scan.comps[0]] {
+			runLength := int32(0)
+			for zig := scan.ss; zig <= scan.se; zig++ {
+				ac := block[zig]
+				if ac == 0 {
+					runLength++
+					continue
+				}
+				for runLength > 15 {
+					e.emitHuff(h, 0xf0)
+					runLength -= 16
+				}
+				e.emitHuffRLE(h, runLength, ac)
+				runLength = 0
+			}
+			if runLength > 0 {
+				e.emitHuff(h, 0x00)
+			}
+		}
+	}
+	e.flush()
+}
+
+// quantizeBlocks разбивает изображение на блоки 8x8, переводит в YCbCr,
+// применяет DCT и квантование. Коэффициенты возвращаются в зигзаг-порядке.
+func quantizeBlocks(m image.Image, nComp int, quant *[2][blockSize]int32) [][][blockSize]int32 {
+	b := m.Bounds()
+	bw, bh := (b.Dx()+7)/8, (b.Dy()+7)/8
+	coeffs := make([][][blockSize]int32, nComp)
+	for c := range coeffs {
+		coeffs[c] = make([][blockSize]int32, bw*bh)
+	}
+
+	var samples [3][blockSize]float64
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			for j := 0; j < 8; j++ {
+				y := min(b.Min.Y+by*8+j, b.Max.Y-1)
+				for i := 0; i < 8; i++ {
+					x := min(b.Min.X+bx*8+i, b.Max.X-1)
+					px := m.At(x, y)
+					if nComp == 1 {
+						samples[0][j*8+i] = float64(color.GrayModel.Convert(px).(color.Gray).Y) - 128
+						continue
+					}
+					r, g, bb, _ := px.RGBA()
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bb>>8))
+					samples[0][j*8+i] = float64(yy) - 128
+					samples[1][j*8+i] = float64(cb) - 128
+					samples[2][j*8+i] = float64(cr) - 128
+				}
+			}
+			for c := 0; c < nComp; c++ {
+				fdct(&samples[c])
+				q := &quant[min(c, 1)]
+				block := &coeffs[c][by*bw+bx]
+				for zig := 0; zig < blockSize; zig++ {
+					block[zig] = int32(math.Round(samples[c][unzig[zig]] / float64(q[zig])))
+				}
+			}
+		}
+	}
+	return coeffs
+}
+
+// dctCos[u][x] = C(u)/2 * cos((2x+1)uπ/16).
+var dctCos = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		cu := 0.5
+		if u == 0 {
+			cu = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = cu * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// fdct выполняет двумерное прямое DCT блока 8x8 на месте.
+func fdct(b *[blockSize]float64) {
+	var tmp [blockSize]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < 8; x++ {
+				s += dctCos[u][x] * b[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var s float64
+			for y := 0; y < 8; y++ {
+				s += dctCos[v][y] * tmp[y*8+u]
+			}
+			b[v*8+u] = s
+		}
+	}
+}
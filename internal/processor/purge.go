@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"imageproxy/internal/cache"
+	"imageproxy/internal/storage"
+)
+
+// Виды записей в кэше.
+const (
+	EntryOriginal = "original"
+	EntryVariant  = "variant"
+	EntryInfo     = "info"
+)
+
+// infoOptions суффикс ключа сведений об изображении в кэше вариантов.
+const infoOptions = "info"
+
+// CacheEntry запись в кэше оригиналов или вариантов.
+type CacheEntry struct {
+	Key string `json:"key"`
+	// Cache имя кэша, в котором хранится запись.
+	Cache string `json:"cache"`
+	// URL исходного изображения без схемы.
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+	// Options канонические параметры обработки варианта без отпечатка
+	// настроек.
+	Options string    `json:"options,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// CacheEntries возвращает записи кэшей оригиналов и вариантов в порядке
+// кэшей и ключей.
+func (p *ImageProcessor) CacheEntries(ctx context.Context) ([]CacheEntry, error) {
+	var entries []CacheEntry
+	for _, c := range p.caches() {
+		stored, err := c.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cache: %w", err)
+		}
+		for _, e := range stored {
+			entries = append(entries, p.newCacheEntry(c, e))
+		}
+	}
+	return entries, nil
+}
+
+// GetCacheEntry возвращает запись кэша по ключу. ok равен false, если
+// записи нет.
+func (p *ImageProcessor) GetCacheEntry(ctx context.Context, key string) (*CacheEntry, bool, error) {
+	for _, c := range p.caches() {
+		stored, err := c.Stat(ctx, key)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, false, fmt.Errorf("failed to stat cache entry: %w", err)
+		}
+		entry := p.newCacheEntry(c, stored)
+		return &entry, true, nil
+	}
+	return nil, false, nil
+}
+
+// newCacheEntry определяет вид записи по кэшу c. Ключ варианта - URL и
+// параметры с отпечатком настроек после последнего '#', в самих параметрах
+// '#' не бывает.
+func (p *ImageProcessor) newCacheEntry(c *cache.LRUCache, e storage.Entry) CacheEntry {
+	entry := CacheEntry{Key: e.Key, Cache: c.Name(), URL: e.Key, Kind: EntryOriginal, Size: e.Size, ModTime: e.ModTime}
+	if c != p.variants {
+		return entry
+	}
+	i := strings.LastIndexByte(e.Key, '#')
+	if i < 0 {
+		return entry
+	}
+	entry.URL = e.Key[:i]
+	if options := e.Key[i+1:]; options == infoOptions {
+		entry.Kind = EntryInfo
+	} else {
+		entry.Kind = EntryVariant
+		if j := strings.LastIndex(options, "/"+configOption+":"); j >= 0 {
+			options = options[:j]
+		}
+		entry.Options = options
+	}
+	return entry
+}
+
+// Purge удаляет из кэшей оригиналы, варианты и сведения изображений,
+// URL которых подходят под match. Возвращает число удаленных записей.
+// Обработка, начатая до удаления, может снова сохранить вариант.
+func (p *ImageProcessor) Purge(ctx context.Context, match func(url string) bool) (int, error) {
+	purged := 0
+	for _, c := range p.caches() {
+		stored, err := c.List(ctx)
+		if err != nil {
+			return purged, fmt.Errorf("failed to list cache: %w", err)
+		}
+		for _, e := range stored {
+			if !match(p.newCacheEntry(c, e).URL) {
+				continue
+			}
+			if err := c.Delete(ctx, e.Key); err != nil {
+				return purged, fmt.Errorf("failed to purge %q: %w", e.Key, err)
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// caches возвращает кэши оригиналов и вариантов, которые заданы.
+func (p *ImageProcessor) caches() []*cache.LRUCache {
+	caches := make([]*cache.LRUCache, 0, 2)
+	for _, c := range []*cache.LRUCache{p.cache, p.variants} {
+		if c != nil {
+			caches = append(caches, c)
+		}
+	}
+	return caches
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	first := newTestOrigin(t, testImage(64, 64))
+	second := newTestOrigin(t, testImage(32, 32))
+	p := newTestProcessor(DefaultConfig())
+
+	for _, url := range []string{first, second} {
+		_, err := p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+		require.NoError(t, err)
+	}
+	_, err := p.ProcessImage(ctx, first, Options{Operations: resize(8, 8)})
+	require.NoError(t, err)
+	_, err = p.GetInfo(ctx, first)
+	require.NoError(t, err)
+
+	entries, err := p.CacheEntries(ctx)
+	require.NoError(t, err)
+	kinds := map[string]int{}
+	var variant CacheEntry
+	for _, entry := range entries {
+		kinds[entry.Kind]++
+		if entry.Kind == EntryVariant && entry.URL == first && strings.HasPrefix(entry.Options, "rs:force:8:8") {
+			variant = entry
+		}
+		assert.Positive(t, entry.Size, entry.Key)
+		assert.False(t, entry.ModTime.IsZero(), entry.Key)
+	}
+	assert.Equal(t, map[string]int{EntryOriginal: 2, EntryVariant: 3, EntryInfo: 1}, kinds)
+
+	require.NotEmpty(t, variant.Key)
+	entry, ok, err := p.GetCacheEntry(ctx, variant.Key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, variant, *entry)
+
+	_, ok, err = p.GetCacheEntry(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Удаляются оригинал, все варианты и сведения
+	purged, err := p.Purge(ctx, func(url string) bool { return url == first })
+	require.NoError(t, err)
+	assert.Equal(t, 4, purged)
+
+	entries, err = p.CacheEntries(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, second, entry.URL)
+	}
+	_, ok = p.VariantInfo(ctx, first, Options{Operations: resize(8, 8)})
+	assert.False(t, ok)
+}
+
+func TestCacheEntries_HashInURL(t *testing.T) {
+	ctx := context.Background()
+	// '#' в пути источника приходит из раскодированного %23
+	url := newTestOrigin(t, testImage(32, 32)) + "#rs:fit:10:10"
+	p := newTestProcessor(DefaultConfig())
+	_, err := p.ProcessImage(ctx, url, Options{Operations: resize(16, 16)})
+	require.NoError(t, err)
+
+	entries, err := p.CacheEntries(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, EntryOriginal, entries[0].Kind)
+	assert.Equal(t, "originals", entries[0].Cache)
+	assert.Equal(t, url, entries[0].URL)
+	assert.Equal(t, EntryVariant, entries[1].Kind)
+	assert.Equal(t, "variants", entries[1].Cache)
+	assert.Equal(t, url, entries[1].URL)
+
+	entry, ok, err := p.GetCacheEntry(ctx, url)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, EntryOriginal, entry.Kind)
+
+	purged, err := p.Purge(ctx, func(u string) bool { return u == url })
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Способы масштабирования.
+const (
+	// ResizeFit вписывает изображение в прямоугольник с сохранением пропорций.
+	ResizeFit = "fit"
+	// ResizeFill заполняет прямоугольник, обрезая лишнее согласно гравитации.
+	ResizeFill = "fill"
+	// ResizeForce растягивает изображение до заданных размеров.
+	ResizeForce = "force"
+)
+
+// gravities допустимые значения гравитации для ResizeFill.
+var gravities = map[string]imaging.Anchor{
+	"ce":   imaging.Center,
+	"no":   imaging.Top,
+	"so":   imaging.Bottom,
+	"ea":   imaging.Right,
+	"we":   imaging.Left,
+	"nowe": imaging.TopLeft,
+	"noea": imaging.TopRight,
+	"sowe": imaging.BottomLeft,
+	"soea": imaging.BottomRight,
+}
+
+// Resize масштабирование. Если одна из сторон равна 0, она вычисляется
+// с сохранением пропорций, и способ масштабирования не важен.
+type Resize struct {
+	Type   string
+	Width  int
+	Height int
+}
+
+func parseResize(args []string) (Operation, error) {
+	if len(args) != 3 {
+		return nil, argsError("rs", 3, args)
+	}
+	width, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid width %q", ErrInvalidOptions, args[1])
+	}
+	height, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid height %q", ErrInvalidOptions, args[2])
+	}
+	return Resize{Type: args[0], Width: width, Height: height}, nil
+}
+
+func (r Resize) Name() string { return "rs" }
+
+func (r Resize) Args() []string {
+	return []string{r.Type, strconv.Itoa(r.Width), strconv.Itoa(r.Height)}
+}
+
+func (r Resize) validate() error {
+	switch r.Type {
+	case ResizeFit, ResizeFill, ResizeForce:
+	default:
+		return fmt.Errorf("%w: unknown resize type %q", ErrInvalidOptions, r.Type)
+	}
+	if r.Width < 0 || r.Height < 0 || r.Width == 0 && r.Height == 0 {
+		return fmt.Errorf("%w: invalid size %dx%d", ErrInvalidOptions, r.Width, r.Height)
+	}
+	return nil
+}
+
+func (r Resize) apply(img image.Image, env *stepEnv) image.Image {
+	if r.Width > 0 && r.Height > 0 {
+		switch r.Type {
+		case ResizeFill:
+			return imaging.Fill(img, r.Width, r.Height, env.gravity, env.filter)
+		case ResizeFit:
+			return imaging.Fit(img, r.Width, r.Height, env.filter)
+		}
+	}
+	return imaging.Resize(img, r.Width, r.Height, env.filter)
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Rotate поворот по часовой стрелке в градусах, приведенный к [0, 360).
+// Свободные области при углах, не кратных 90, заполняются цветом фона.
+type Rotate float64
+
+// NewRotate приводит угол к диапазону [0, 360).
+func NewRotate(angle float64) Rotate {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	return Rotate(angle)
+}
+
+func (r Rotate) Name() string    { return "rot" }
+func (r Rotate) Args() []string  { return []string{formatFloat(float64(r))} }
+func (r Rotate) validate() error { return nil }
+
+// rightAngle сообщает, что поворот выполняется без интерполяции и фона.
+func (r Rotate) rightAngle() bool {
+	return math.Mod(float64(r), 90) == 0
+}
+
+func (r Rotate) apply(img image.Image, env *stepEnv) image.Image {
+	// В imaging углы отсчитываются против часовой стрелки
+	switch r {
+	case 0:
+		return img
+	case 90:
+		return imaging.Rotate270(img)
+	case 180:
+		return imaging.Rotate180(img)
+	case 270:
+		return imaging.Rotate90(img)
+	}
+	return imaging.Rotate(img, -float64(r), env.background)
+}
+
+// FlipH отражение по горизонтали.
+type FlipH struct{}
+
+func (FlipH) Name() string                                  { return "fh" }
+func (FlipH) Args() []string                                { return nil }
+func (FlipH) validate() error                               { return nil }
+func (FlipH) apply(img image.Image, _ *stepEnv) image.Image { return imaging.FlipH(img) }
+
+// FlipV отражение по вертикали.
+type FlipV struct{}
+
+func (FlipV) Name() string                                  { return "fv" }
+func (FlipV) Args() []string                                { return nil }
+func (FlipV) validate() error                               { return nil }
+func (FlipV) apply(img image.Image, _ *stepEnv) image.Image { return imaging.FlipV(img) }
+
+// Transpose отражение относительно главной диагонали.
+type Transpose struct{}
+
+func (Transpose) Name() string                                  { return "tp" }
+func (Transpose) Args() []string                                { return nil }
+func (Transpose) validate() error                               { return nil }
+func (Transpose) apply(img image.Image, _ *stepEnv) image.Image { return imaging.Transpose(img) }
+
+// Transverse отражение относительно побочной диагонали.
+type Transverse struct{}
+
+func (Transverse) Name() string                                  { return "tv" }
+func (Transverse) Args() []string                                { return nil }
+func (Transverse) validate() error                               { return nil }
+func (Transverse) apply(img image.Image, _ *stepEnv) image.Image { return imaging.Transverse(img) }
+
+// parseColor разбирает цвет в формате RRGGBB или RRGGBBAA. Пустая строка
+// означает прозрачный цвет.
+func parseColor(s string) (color.NRGBA, error) {
+	if s == "" {
+		return color.NRGBA{}, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 3 && len(b) != 4 {
+		return color.NRGBA{}, fmt.Errorf("%w: invalid color %q", ErrInvalidOptions, s)
+	}
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 255}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// markedImage возвращает изображение 40x20 с красной точкой в левом верхнем углу.
+func markedImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, A: 255})
+	return img
+}
+
+func TestRotate_Apply(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	testCases := []struct {
+		name   string
+		op     Operation
+		size   image.Point
+		marker image.Point
+	}{
+		{"None", NewRotate(0), image.Pt(40, 20), image.Pt(0, 0)},
+		{"Rotate 90", NewRotate(90), image.Pt(20, 40), image.Pt(19, 0)},
+		{"Rotate 180", NewRotate(180), image.Pt(40, 20), image.Pt(39, 19)},
+		{"Rotate -90", NewRotate(-90), image.Pt(20, 40), image.Pt(0, 39)},
+		{"Flip horizontal", FlipH{}, image.Pt(40, 20), image.Pt(39, 0)},
+		{"Flip vertical", FlipV{}, image.Pt(40, 20), image.Pt(0, 19)},
+		{"Transpose", Transpose{}, image.Pt(20, 40), image.Pt(0, 0)},
+		{"Transverse", Transverse{}, image.Pt(20, 40), image.Pt(19, 39)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			img := tc.op.apply(markedImage(), &stepEnv{})
+			assert.Equal(t, tc.size, img.Bounds().Size())
+			assert.Equal(t, red, color.NRGBAModel.Convert(img.At(tc.marker.X, tc.marker.Y)))
+		})
+	}
+}
+
+func TestRotate_ArbitraryAngle(t *testing.T) {
+	img := NewRotate(45).apply(markedImage(), &stepEnv{background: color.NRGBA{G: 255, A: 255}})
+	assert.Greater(t, img.Bounds().Dx(), 40)
+	assert.Equal(t, color.NRGBA{G: 255, A: 255}, color.NRGBAModel.Convert(img.At(0, 0)))
+
+	assert.Equal(t, NewRotate(90), NewRotate(450))
+
+	p := newTestProcessor(DefaultConfig())
+	_, err := p.normalize(Options{Operations: []Operation{NewRotate(45)}, Background: "green"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	// Для кратных 90 углов фон не влияет на ключ кэша
+	opts, err := p.normalize(Options{Operations: []Operation{NewRotate(90)}, Background: "00ff00"})
+	require.NoError(t, err)
+	assert.Empty(t, opts.Background)
+}
+
+func TestProcessImage_RotateBeforeResize(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, markedImage()))
+	url := newTestOriginData(t, buf.Bytes())
+	p := newTestProcessor(DefaultConfig())
+
+	// Высота 0 сохраняет пропорции уже повернутого изображения
+	ops := []Operation{NewRotate(90), Resize{Type: ResizeForce, Width: 10}}
+	result, err := p.ProcessImage(context.Background(), url, Options{Operations: ops, Format: FormatPNG})
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(10, 20), img.Bounds().Size())
+}
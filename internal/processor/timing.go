@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"imageproxy/internal/metrics"
+)
+
+// Стадии обработки в Result.Timings.
+const (
+	TimingCache     = "cache"
+	TimingFetch     = "fetch"
+	TimingDecode    = "decode"
+	TimingTransform = "transform"
+	TimingEncode    = "encode"
+)
+
+// Timing суммарная длительность стадии обработки одного запроса.
+type Timing struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// stage стадия рендеринга: имя в трассах и Result.Timings и метка метрик.
+type stage struct {
+	name   string
+	metric string
+}
+
+var (
+	stageDecode    = stage{name: TimingDecode, metric: metrics.StageDecode}
+	stageTransform = stage{name: TimingTransform, metric: metrics.StageResize}
+	stageEncode    = stage{name: TimingEncode, metric: metrics.StageEncode}
+)
+
+// startStage начинает стадию рендеринга: span трассировки и замер времени
+// для метрик и Result.Timings. Возвращает функцию, которая завершает стадию.
+func startStage(ctx context.Context, s stage) func() {
+	start := time.Now()
+	_, span := tracer.Start(ctx, s.name)
+	return func() {
+		metrics.ObserveStage(s.metric, start)
+		recordTiming(ctx, s.name, start)
+		span.End()
+	}
+}
+
+// timings накапливает длительности стадий одной обработки.
+type timings struct {
+	mu   sync.Mutex
+	list []Timing
+}
+
+type timingsKey struct{}
+
+// withTimings возвращает контекст, в котором стадии обработки записывают
+// свои длительности в t.
+func withTimings(ctx context.Context) (context.Context, *timings) {
+	t := &timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// recordTiming добавляет время, прошедшее с start, к стадии name.
+// Повторные стадии, например несколько обращений к кэшу, суммируются.
+func recordTiming(ctx context.Context, name string, start time.Time) {
+	t, ok := ctx.Value(timingsKey{}).(*timings)
+	if !ok {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.list {
+		if t.list[i].Stage == name {
+			t.list[i].Duration += d
+			return
+		}
+	}
+	t.list = append(t.list, Timing{Stage: name, Duration: d})
+}
+
+// get возвращает длительности стадий в порядке их первого выполнения.
+func (t *timings) get() []Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Timing(nil), t.list...)
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"imageproxy/internal/storage"
+)
+
+// variantMeta метаданные обработанного изображения. Хранятся в кэше
+// вариантов одной строкой JSON перед данными изображения.
+type variantMeta struct {
+	ContentType string `json:"contentType"`
+	Quality     int    `json:"quality,omitempty"`
+	// OriginalWidth и OriginalHeight размеры исходного изображения.
+	OriginalWidth  int `json:"originalWidth,omitempty"`
+	OriginalHeight int `json:"originalHeight,omitempty"`
+	// ETag валидатор варианта, LastModified - время обработки в секундах Unix.
+	ETag         string `json:"etag,omitempty"`
+	LastModified int64  `json:"lastModified,omitempty"`
+}
+
+func newVariantMeta(result *Result) variantMeta {
+	return variantMeta{
+		ContentType:    result.ContentType,
+		Quality:        result.Quality,
+		OriginalWidth:  result.OriginalWidth,
+		OriginalHeight: result.OriginalHeight,
+		ETag:           result.ETag,
+		LastModified:   result.LastModified.Unix(),
+	}
+}
+
+// result возвращает результат из кэша вариантов без данных изображения.
+func (m variantMeta) result() *Result {
+	result := &Result{
+		ContentType:    m.ContentType,
+		Quality:        m.Quality,
+		CacheStatus:    CacheHit,
+		OriginalWidth:  m.OriginalWidth,
+		OriginalHeight: m.OriginalHeight,
+		ETag:           m.ETag,
+	}
+	if m.LastModified > 0 {
+		result.LastModified = time.Unix(m.LastModified, 0).UTC()
+	}
+	return result
+}
+
+// writeVariantMeta пишет строку метаданных, за которой следуют данные
+// изображения.
+func writeVariantMeta(w io.Writer, meta variantMeta) error {
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(header, '\n'))
+	return err
+}
+
+// readVariantMeta читает метаданные записи, не читая данные изображения.
+// Возвращает также смещение данных изображения в записи.
+func readVariantMeta(r storage.Reader) (variantMeta, int64, error) {
+	var meta variantMeta
+	header, err := bufio.NewReader(io.NewSectionReader(r, 0, r.Size())).ReadBytes('\n')
+	if errors.Is(err, io.EOF) {
+		return meta, 0, errors.New("variant header is missing")
+	} else if err != nil {
+		return meta, 0, err
+	}
+	if err := json.Unmarshal(header, &meta); err != nil {
+		return meta, 0, fmt.Errorf("invalid variant header: %w", err)
+	}
+	return meta, int64(len(header)), nil
+}
+
+// variantBody данные изображения из записи в кэше вариантов.
+type variantBody struct {
+	*io.SectionReader
+	io.Closer
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// concurrencyRetryAfter время, через которое клиенту, превысившему число
+// одновременных запросов, предлагается повторить запрос.
+const concurrencyRetryAfter = time.Second
+
+// Concurrency ограничивает число одновременных запросов каждого клиента.
+// Nil Concurrency не ограничивает запросы.
+type Concurrency struct {
+	limit int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// NewConcurrency создает ограничитель на limit одновременных запросов
+// клиента. При limit <= 0 возвращает nil, и запросы не ограничиваются.
+func NewConcurrency(limit int) *Concurrency {
+	if limit <= 0 {
+		return nil
+	}
+	return &Concurrency{limit: limit, inFlight: make(map[string]int)}
+}
+
+// Acquire занимает место для запроса клиента key и возвращает функцию,
+// освобождающую его. Если клиент уже выполняет limit запросов, возвращает
+// *LimitError.
+func (c *Concurrency) Acquire(key string) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight[key] >= c.limit {
+		return nil, &LimitError{RetryAfter: concurrencyRetryAfter}
+	}
+	c.inFlight[key]++
+	var once sync.Once
+	return func() { once.Do(func() { c.release(key) }) }, nil
+}
+
+// release освобождает место клиента key. Счетчики клиентов без запросов
+// удаляются.
+func (c *Concurrency) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight[key]--; c.inFlight[key] <= 0 {
+		delete(c.inFlight, key)
+	}
+}
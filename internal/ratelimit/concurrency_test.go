@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrency_Acquire(t *testing.T) {
+	c := NewConcurrency(2)
+
+	release1, err := c.Acquire("a")
+	require.NoError(t, err)
+	release2, err := c.Acquire("a")
+	require.NoError(t, err)
+	_, err = c.Acquire("a")
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, time.Second, limitErr.RetryAfter)
+
+	// Клиенты ограничиваются независимо
+	releaseB, err := c.Acquire("b")
+	require.NoError(t, err)
+	releaseB()
+
+	// Повторное освобождение не дает лишнего места
+	release1()
+	release1()
+	release3, err := c.Acquire("a")
+	require.NoError(t, err)
+	_, err = c.Acquire("a")
+	assert.Error(t, err)
+
+	release2()
+	release3()
+	assert.Empty(t, c.inFlight)
+}
+
+func TestConcurrency_Disabled(t *testing.T) {
+	c := NewConcurrency(0)
+	assert.Nil(t, c)
+	for i := 0; i < 100; i++ {
+		_, err := c.Acquire("a")
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package ratelimit ограничивает частоту запросов клиентов алгоритмом
+// token bucket и число их одновременных запросов.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneInterval период удаления корзин неактивных клиентов.
+const pruneInterval = time.Minute
+
+// LimitError запрос отклонен, следующий токен появится через RetryAfter.
+type LimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter набор корзин токенов по ключам клиентов. Nil Limiter не ограничивает
+// запросы.
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// New создает ограничитель на rate запросов в секунду с запасом burst.
+// При rate <= 0 возвращает nil, и запросы не ограничиваются.
+func New(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow забирает токен из корзины клиента key. Если токенов нет, возвращает
+// *LimitError.
+func (l *Limiter) Allow(key string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return &LimitError{RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// prune удаляет заполненные корзины: они не отличаются от новых.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Allow("a"))
+	}
+	err := l.Allow("a")
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, 500*time.Millisecond, limitErr.RetryAfter)
+
+	// Корзины клиентов независимы
+	assert.NoError(t, l.Allow("b"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.NoError(t, l.Allow("a"))
+	assert.Error(t, l.Allow("a"))
+
+	// Заполненные корзины удаляются
+	now = now.Add(time.Hour)
+	assert.NoError(t, l.Allow("c"))
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l := New(0, 10)
+	assert.Nil(t, l)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, l.Allow("a"))
+	}
+}
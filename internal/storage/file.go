@@ -2,19 +2,31 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 )
 
+// partialPrefix префикс временных файлов, в которые пишутся данные до
+// переименования в файл ключа.
+const partialPrefix = ".partial-"
+
 type FileStorage struct {
 	baseDir string
 	mu      sync.RWMutex
 	size    int
+	closed  bool
+	// writers незавершенные потоковые записи, которых дожидается Close.
+	writers sync.WaitGroup
 }
 
 func NewFileStorage(baseDir string) (*FileStorage, error) {
@@ -53,29 +65,150 @@ func NewFileStorage(baseDir string) (*FileStorage, error) {
 	}
 	os.Remove(testFile)
 
-	entries, err := os.ReadDir(baseDir)
+	store := &FileStorage{baseDir: baseDir}
+	if err := store.cleanup(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Имена служебных файлов. Они не получаются при кодировании ключей: '%'
+// в именах значений продолжается двумя шестнадцатеричными цифрами, а точка
+// в начале имени кодируется.
+const (
+	// emptyKeyName имя файла пустого ключа.
+	emptyKeyName = "%"
+	// layoutFile хранит версию правил именования файлов в каталоге.
+	layoutFile = ".layout"
+	// hashedPrefix начинает имена файлов ключей, которые после кодирования
+	// длиннее maxNameLength: за ним идет SHA-256 ключа. Сам ключ хранится
+	// в файле с тем же именем и суффиксом keySuffix.
+	hashedPrefix = "%H"
+	keySuffix    = ".key"
+)
+
+// layoutVersion версия правил именования: прежние версии заменяли
+// недопустимые символы на '_' без возможности восстановить ключ.
+const layoutVersion = "2"
+
+// maxNameLength максимальная длина имени файла (NAME_MAX).
+const maxNameLength = 255
+
+// cleanup удаляет недописанные файлы, оставшиеся после аварийного
+// завершения, и файлы ключей без значений, считает сохраненные значения.
+// Файлы, названные прежними версиями, нельзя сопоставить ключам, поэтому
+// они удаляются, чтобы не занимать место без возможности вытеснения.
+func (s *FileStorage) cleanup() error {
+	layout, err := os.ReadFile(filepath.Join(s.baseDir, layoutFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read layout file: %w", err)
+	}
+	legacy := string(layout) != layoutVersion
+
+	entries, err := os.ReadDir(s.baseDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read base directory: %w", err)
+		return fmt.Errorf("failed to read base directory: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || name == layoutFile {
+			continue
+		}
+		remove := legacy || strings.HasPrefix(name, partialPrefix)
+		if !remove && isKeyFile(name) {
+			_, err := os.Stat(filepath.Join(s.baseDir, strings.TrimSuffix(name, keySuffix)))
+			remove = os.IsNotExist(err)
+		}
+		if !remove {
+			if !isKeyFile(name) {
+				s.size++
+			}
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.baseDir, name)); err != nil {
+			return fmt.Errorf("failed to remove stale file: %w", err)
+		}
+		if legacy && !strings.HasPrefix(name, partialPrefix) {
+			removed++
+		}
 	}
 
-	return &FileStorage{
-		baseDir: baseDir,
-		size:    len(entries),
-	}, nil
+	if legacy {
+		if removed > 0 {
+			slog.Info("Removed cache files of the previous naming layout", "dir", s.baseDir, "files", removed)
+		}
+		if err := writeFileAtomic(filepath.Join(s.baseDir, layoutFile), []byte(layoutVersion)); err != nil {
+			return fmt.Errorf("failed to write layout file: %w", err)
+		}
+	}
+	return nil
 }
 
+// isKeyFile сообщает, что name - файл с ключом длинного значения.
+func isKeyFile(name string) bool {
+	return strings.HasPrefix(name, hashedPrefix) && strings.HasSuffix(name, keySuffix)
+}
+
+// sanitizeKey возвращает имя файла ключа. Разделители путей, символы,
+// недопустимые в именах файлов, '%' и точка в начале имени кодируются
+// как %XX, поэтому ключ восстанавливается по имени файла. Слишком длинные
+// имена заменяются хешем ключа.
 func (s *FileStorage) sanitizeKey(key string) string {
 	if key == "" {
-		return "empty"
+		return emptyKeyName
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c < ' ' || c == 0x7f || strings.IndexByte(`%/\:*?"<>|`, c) >= 0 || i == 0 && c == '.' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
 	}
-	key = strings.ReplaceAll(key, ":", "_")
-	key = strings.ReplaceAll(key, "/", "_")
-	key = strings.ReplaceAll(key, "\\", "_")
-	key = strings.ReplaceAll(key, "..", "__")
-	return strings.TrimLeft(key, "_")
+	if b.Len() > maxNameLength {
+		sum := sha256.Sum256([]byte(key))
+		return hashedPrefix + hex.EncodeToString(sum[:])
+	}
+	return b.String()
+}
+
+// fileKey восстанавливает ключ по имени файла. Ключ хешированного имени
+// читается из файла ключа. Возвращает false для файлов, которые не являются
+// значениями хранилища.
+func (s *FileStorage) fileKey(name string) (string, bool) {
+	if name == emptyKeyName {
+		return "", true
+	}
+	if strings.HasPrefix(name, hashedPrefix) {
+		key, err := os.ReadFile(filepath.Join(s.baseDir, name+keySuffix))
+		if err != nil || s.sanitizeKey(string(key)) != name {
+			return "", false
+		}
+		return string(key), true
+	}
+	key, err := url.PathUnescape(name)
+	if err != nil || s.sanitizeKey(key) != name {
+		return "", false
+	}
+	return key, true
+}
+
+// writeKeyFile сохраняет ключ значения с хешированным именем path.
+func writeKeyFile(path, key string) error {
+	if !strings.HasPrefix(filepath.Base(path), hashedPrefix) {
+		return nil
+	}
+	return writeFileAtomic(path+keySuffix, []byte(key))
 }
 
 func (s *FileStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.open(ctx, key)
+}
+
+func (s *FileStorage) open(ctx context.Context, key string) (*os.File, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
@@ -104,6 +237,9 @@ func (s *FileStorage) Set(ctx context.Context, key string, data []byte) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
 
 	safeKey := s.sanitizeKey(key)
 	path := filepath.Join(s.baseDir, safeKey)
@@ -117,8 +253,14 @@ func (s *FileStorage) Set(ctx context.Context, key string, data []byte) error {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	// Записываем файл
-	if err := os.WriteFile(path, data, 0o600); err != nil {
+	// Записываем во временный файл и переименовываем, чтобы при аварийном
+	// завершении не остался недописанный файл ключа
+	if err := writeKeyFile(path, key); err != nil {
+		slog.ErrorContext(ctx, "Storage write failed", "path", path, "error", err)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		slog.ErrorContext(ctx, "Storage write failed", "path", path, "error", err)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
@@ -130,6 +272,42 @@ func (s *FileStorage) Set(ctx context.Context, key string, data []byte) error {
 	return nil
 }
 
+func (s *FileStorage) Open(ctx context.Context, key string) (Reader, error) {
+	file, err := s.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return &fileReader{File: file, size: info.Size()}, nil
+}
+
+func (s *FileStorage) Create(ctx context.Context, key string) (Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	s.writers.Add(1)
+	s.mu.RUnlock()
+
+	// Данные пишутся во временный файл и переименовываются при Commit
+	path := filepath.Join(s.baseDir, s.sanitizeKey(key))
+	file, err := os.CreateTemp(filepath.Dir(path), partialPrefix+"*")
+	if err != nil {
+		s.writers.Done()
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	return &fileWriter{storage: s, ctx: ctx, key: key, path: path, file: file}, nil
+}
+
 func (s *FileStorage) Delete(ctx context.Context, key string) error {
 	if err := ctx.Err(); err != nil {
 		return err
@@ -137,6 +315,9 @@ func (s *FileStorage) Delete(ctx context.Context, key string) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
 
 	safeKey := s.sanitizeKey(key)
 	path := filepath.Join(s.baseDir, safeKey)
@@ -148,15 +329,184 @@ func (s *FileStorage) Delete(ctx context.Context, key string) error {
 
 	// Удаляем файл
 	if err := os.Remove(path); err != nil {
+		slog.ErrorContext(ctx, "Storage delete failed", "path", path, "error", err)
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+	if strings.HasPrefix(safeKey, hashedPrefix) {
+		if err := os.Remove(path + keySuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete key file: %w", err)
+		}
+	}
 
 	s.size--
 	return nil
 }
 
+func (s *FileStorage) Stat(ctx context.Context, key string) (Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return Entry{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	path := filepath.Join(s.baseDir, s.sanitizeKey(key))
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Entry{}, os.ErrNotExist
+		}
+		return Entry{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return Entry{}, os.ErrNotExist
+	}
+
+	return Entry{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FileStorage) List(ctx context.Context) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dirEntries, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base directory: %w", err)
+	}
+
+	// Кодирование меняет порядок, поэтому ключи сортируются заново
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || strings.HasPrefix(dirEntry.Name(), partialPrefix) {
+			continue
+		}
+		key, ok := s.fileKey(dirEntry.Name())
+		if !ok {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		entries = append(entries, Entry{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Key, b.Key) })
+	return entries, nil
+}
+
 func (s *FileStorage) Size() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.size
 }
+
+// Close запрещает новые записи и дожидается Commit или Abort начатых
+// потоковых записей.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.writers.Wait()
+	return nil
+}
+
+type fileReader struct {
+	*os.File
+	size int64
+}
+
+func (r *fileReader) Size() int64 {
+	return r.size
+}
+
+// fileWriter пишет значение во временный файл, который при Commit
+// переименовывается в файл ключа.
+type fileWriter struct {
+	storage *FileStorage
+	ctx     context.Context
+	key     string
+	path    string
+	file    *os.File
+	done    bool
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	return w.file.Write(p)
+}
+
+func (w *fileWriter) Commit() error {
+	if w.done {
+		return os.ErrClosed
+	}
+	w.done = true
+	defer w.storage.writers.Done()
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// Запись, начатая до Close, сохраняется: Close ее дожидается
+	s := w.storage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := os.Stat(w.path)
+	exists := !os.IsNotExist(err)
+	if err := writeKeyFile(w.path, w.key); err != nil {
+		slog.ErrorContext(w.ctx, "Storage write failed", "path", w.path, "error", err)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		slog.ErrorContext(w.ctx, "Storage write failed", "path", w.path, "error", err)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if !exists {
+		s.size++
+	}
+	return nil
+}
+
+func (w *fileWriter) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.file.Close()
+	os.Remove(w.file.Name())
+	w.storage.writers.Done()
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), partialPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
@@ -96,6 +97,115 @@ func TestFileStorage_SanitizeKey(t *testing.T) {
 	}
 }
 
+func TestFileStorage_KeyEncoding(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"With colon", "host:port/path", "host%3Aport%2Fpath"},
+		{"With slashes", "path/to/file", "path%2Fto%2Ffile"},
+		{"With backslashes", "path\\to\\file", "path%5Cto%5Cfile"},
+		{"With dots", "../parent", "%2E.%2Fparent"},
+		{"Mixed", "host:8080/path/../file", "host%3A8080%2Fpath%2F..%2Ffile"},
+		{"With percent", "a%20b", "a%2520b"},
+		{"Variant", "localhost:8080/images/1.jpg#rs:fit:300:200", "localhost%3A8080%2Fimages%2F1.jpg#rs%3Afit%3A300%3A200"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, store.Set(ctx, tc.key, []byte("data")))
+			sanitized := store.sanitizeKey(tc.key)
+			assert.Equal(t, tc.expected, sanitized)
+			_, err := os.Stat(filepath.Join(tempDir, sanitized))
+			assert.NoError(t, err)
+
+			// Ключ восстанавливается по имени файла
+			key, ok := store.fileKey(sanitized)
+			assert.True(t, ok)
+			assert.Equal(t, tc.key, key)
+
+			require.NoError(t, store.Delete(ctx, tc.key))
+		})
+	}
+}
+
+func TestFileStorage_LongKey(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+
+	// После кодирования ключ длиннее NAME_MAX
+	key := "variants/example.com/" + strings.Repeat("a/", 150) + "1.jpg#rs:fit:300:200"
+	name := store.sanitizeKey(key)
+	assert.LessOrEqual(t, len(name)+len(keySuffix), maxNameLength)
+
+	require.NoError(t, store.Set(ctx, key, []byte("data")))
+	w, err := store.Create(ctx, key+"/q:80")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+
+	entries, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, key, entries[0].Key)
+	assert.Equal(t, key+"/q:80", entries[1].Key)
+	r, err := store.Open(ctx, key+"/q:80")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), r.Size())
+	r.Close()
+
+	// После перезапуска значения и их ключи сохраняются
+	store, err = NewFileStorage(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.Size())
+	entry, err := store.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), entry.Size)
+
+	// Удаление убирает и файл ключа
+	require.NoError(t, store.Delete(ctx, key))
+	require.NoError(t, store.Delete(ctx, key+"/q:80"))
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, layoutFile, files[0].Name())
+}
+
+func TestFileStorage_LegacyLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	// Файлы прежних версий с ключами, замененными на '_'
+	for _, name := range []string{"localhost_8080_images_002.jpg", "empty"} {
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte("old"), 0o600))
+	}
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, store.Size())
+	entries, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Файлы текущей версии при перезапуске остаются
+	require.NoError(t, store.Set(ctx, "localhost:8080/images/002.jpg", []byte("new")))
+	store, err = NewFileStorage(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Size())
+	entries, err = store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "localhost:8080/images/002.jpg", entries[0].Key)
+}
+
 func TestFileStorage_ConcurrentAccess(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "filestorage_test")
 	require.NoError(t, err)
@@ -271,3 +381,60 @@ func TestNewFileStorage(t *testing.T) {
 		assert.Contains(t, err.Error(), "no write access")
 	})
 }
+
+func TestFileStorage_Close(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	// Недописанный файл от предыдущего запуска удаляется
+	partial := filepath.Join(tempDir, partialPrefix+"123")
+	require.NoError(t, os.WriteFile(partial, []byte("part"), 0o600))
+
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, store.Size())
+	_, err = os.Stat(partial)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, store.Set(ctx, "key1", []byte("data")))
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2) // key1 и .layout
+
+	require.NoError(t, store.Close())
+	assert.ErrorIs(t, store.Set(ctx, "key2", []byte("data")), ErrClosed)
+	assert.ErrorIs(t, store.Delete(ctx, "key1"), ErrClosed)
+
+	// Чтение после закрытия доступно
+	reader, err := store.Get(ctx, "key1")
+	require.NoError(t, err)
+	reader.Close()
+}
+
+func TestFileStorage_Streaming(t *testing.T) {
+	tempDir := t.TempDir()
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+	testStreaming(t, store)
+
+	// Временные файлы отмененных записей удаляются
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3) // key1, key3 и .layout
+}
+
+func TestFileStorage_List(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStorage(tempDir)
+	require.NoError(t, err)
+
+	testList(t, store)
+
+	// Посторонние и недописанные файлы не считаются значениями
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "bad%name"), []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, partialPrefix+"1"), []byte("x"), 0o600))
+	entries, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
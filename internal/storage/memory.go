@@ -5,17 +5,28 @@ import (
 	"context"
 	"io"
 	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 type MemoryStorage struct {
-	mu   sync.RWMutex
-	data map[string][]byte
+	mu     sync.RWMutex
+	data   map[string]memoryEntry
+	closed bool
+	// writers незавершенные потоковые записи, которых дожидается Close.
+	writers sync.WaitGroup
+}
+
+type memoryEntry struct {
+	value   []byte
+	modTime time.Time
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		data: make(map[string][]byte),
+		data: make(map[string]memoryEntry),
 	}
 }
 
@@ -27,12 +38,12 @@ func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, err
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	data, ok := s.data[key]
+	entry, ok := s.data[key]
 	if !ok {
 		return nil, os.ErrNotExist
 	}
 
-	return io.NopCloser(bytes.NewReader(data)), nil
+	return io.NopCloser(bytes.NewReader(entry.value)), nil
 }
 
 func (s *MemoryStorage) Set(ctx context.Context, key string, data []byte) error {
@@ -42,11 +53,45 @@ func (s *MemoryStorage) Set(ctx context.Context, key string, data []byte) error
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
 
-	s.data[key] = data
+	s.data[key] = memoryEntry{value: data, modTime: time.Now()}
 	return nil
 }
 
+func (s *MemoryStorage) Open(ctx context.Context, key string) (Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.data[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return memoryReader{bytes.NewReader(entry.value)}, nil
+}
+
+func (s *MemoryStorage) Create(ctx context.Context, key string) (Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	s.writers.Add(1)
+	return &memoryWriter{storage: s, key: key}, nil
+}
+
 func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
 	if err := ctx.Err(); err != nil {
 		return err
@@ -54,13 +99,104 @@ func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
 
 	delete(s.data, key)
 	return nil
 }
 
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return Entry{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.data[key]
+	if !ok {
+		return Entry{}, os.ErrNotExist
+	}
+
+	return Entry{Key: key, Size: int64(len(entry.value)), ModTime: entry.modTime}, nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(s.data))
+	for key, entry := range s.data {
+		entries = append(entries, Entry{Key: key, Size: int64(len(entry.value)), ModTime: entry.modTime})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Key, b.Key) })
+	return entries, nil
+}
+
 func (s *MemoryStorage) Size() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return len(s.data)
 }
+
+// Close запрещает новые записи и дожидается Commit или Abort начатых
+// потоковых записей.
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.writers.Wait()
+	return nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// memoryWriter накапливает значение и сохраняет его при Commit.
+type memoryWriter struct {
+	storage *MemoryStorage
+	key     string
+	buf     bytes.Buffer
+	done    bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Commit() error {
+	if w.done {
+		return os.ErrClosed
+	}
+	w.done = true
+	defer w.storage.writers.Done()
+
+	// Запись, начатая до Close, сохраняется: Close ее дожидается
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+
+	w.storage.data[w.key] = memoryEntry{value: w.buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (w *memoryWriter) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.storage.writers.Done()
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMemoryStorage_BasicOperations(t *testing.T) {
@@ -163,3 +164,25 @@ func TestMemoryStorage_ContextTimeout(t *testing.T) {
 	_, err := store.Get(ctx, "key")
 	assert.ErrorIs(t, err, context.DeadlineExceeded)
 }
+
+func TestMemoryStorage_Close(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	require.NoError(t, store.Set(ctx, "key1", []byte("data")))
+
+	require.NoError(t, store.Close())
+	assert.ErrorIs(t, store.Set(ctx, "key2", []byte("data")), ErrClosed)
+	assert.ErrorIs(t, store.Delete(ctx, "key1"), ErrClosed)
+
+	reader, err := store.Get(ctx, "key1")
+	require.NoError(t, err)
+	reader.Close()
+}
+
+func TestMemoryStorage_Streaming(t *testing.T) {
+	testStreaming(t, NewMemoryStorage())
+}
+
+func TestMemoryStorage_List(t *testing.T) {
+	testList(t, NewMemoryStorage())
+}
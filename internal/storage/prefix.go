@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// PrefixStorage часть общего хранилища с ключами, начинающимися с prefix.
+// Кэши с разными префиксами в одном хранилище не видят значений друг друга.
+type PrefixStorage struct {
+	storage Storage
+	prefix  string
+}
+
+// WithPrefix возвращает часть хранилища s с ключами prefix+key.
+func WithPrefix(s Storage, prefix string) *PrefixStorage {
+	return &PrefixStorage{storage: s, prefix: prefix}
+}
+
+func (s *PrefixStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.storage.Get(ctx, s.prefix+key)
+}
+
+func (s *PrefixStorage) Set(ctx context.Context, key string, data []byte) error {
+	return s.storage.Set(ctx, s.prefix+key, data)
+}
+
+func (s *PrefixStorage) Open(ctx context.Context, key string) (Reader, error) {
+	return s.storage.Open(ctx, s.prefix+key)
+}
+
+func (s *PrefixStorage) Create(ctx context.Context, key string) (Writer, error) {
+	return s.storage.Create(ctx, s.prefix+key)
+}
+
+func (s *PrefixStorage) Delete(ctx context.Context, key string) error {
+	return s.storage.Delete(ctx, s.prefix+key)
+}
+
+func (s *PrefixStorage) Stat(ctx context.Context, key string) (Entry, error) {
+	entry, err := s.storage.Stat(ctx, s.prefix+key)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Key = key
+	return entry, nil
+}
+
+// List возвращает значения с префиксом в порядке ключей без префикса.
+func (s *PrefixStorage) List(ctx context.Context) ([]Entry, error) {
+	all, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, entry := range all {
+		if key, ok := strings.CutPrefix(entry.Key, s.prefix); ok {
+			entry.Key = key
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Size возвращает число значений с префиксом. Значения перечисляются,
+// поэтому вызов дорогой.
+func (s *PrefixStorage) Size() int {
+	entries, err := s.List(context.Background())
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// Close закрывает общее хранилище.
+func (s *PrefixStorage) Close() error {
+	return s.storage.Close()
+}
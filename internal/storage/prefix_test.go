@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixStorage_Streaming(t *testing.T) {
+	testStreaming(t, WithPrefix(NewMemoryStorage(), "variants/"))
+}
+
+func TestPrefixStorage_List(t *testing.T) {
+	testList(t, WithPrefix(NewMemoryStorage(), "variants/"))
+}
+
+func TestPrefixStorage_Isolation(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryStorage()
+	originals := WithPrefix(shared, "originals/")
+	variants := WithPrefix(shared, "variants/")
+
+	require.NoError(t, originals.Set(ctx, "example.com/a.jpg", []byte("original")))
+	require.NoError(t, variants.Set(ctx, "example.com/a.jpg", []byte("variant")))
+
+	entries, err := originals.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "example.com/a.jpg", entries[0].Key)
+	assert.Equal(t, int64(8), entries[0].Size)
+	assert.Equal(t, 1, variants.Size())
+	assert.Equal(t, 2, shared.Size())
+
+	entry, err := variants.Stat(ctx, "example.com/a.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, Entry{Key: "example.com/a.jpg", Size: 7, ModTime: entry.ModTime}, entry)
+
+	require.NoError(t, variants.Delete(ctx, "example.com/a.jpg"))
+	_, err = variants.Stat(ctx, "example.com/a.jpg")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = originals.Stat(ctx, "example.com/a.jpg")
+	assert.NoError(t, err)
+}
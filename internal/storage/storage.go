@@ -2,12 +2,58 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 )
 
+// ErrClosed возвращается при записи в закрытое хранилище.
+var ErrClosed = errors.New("storage is closed")
+
 type Storage interface {
 	Get(ctx context.Context, key string) (io.ReadCloser, error)
 	Set(ctx context.Context, key string, data []byte) error
+	// Open открывает значение для чтения с произвольной позиции. Если ключа
+	// нет, возвращает os.ErrNotExist.
+	Open(ctx context.Context, key string) (Reader, error)
+	// Create начинает потоковую запись значения. Значение появляется в
+	// хранилище только после Writer.Commit.
+	Create(ctx context.Context, key string) (Writer, error)
 	Delete(ctx context.Context, key string) error
+	// Stat возвращает сведения о значении. Если ключа нет, возвращает
+	// os.ErrNotExist.
+	Stat(ctx context.Context, key string) (Entry, error)
+	// List возвращает все сохраненные значения в порядке ключей.
+	List(ctx context.Context) ([]Entry, error)
 	Size() int
+	// Close запрещает новые записи и дожидается Commit или Abort начатых
+	// потоковых записей.
+	Close() error
+}
+
+// Entry сведения о сохраненном значении.
+type Entry struct {
+	Key  string
+	Size int64
+	// ModTime время сохранения значения.
+	ModTime time.Time
+}
+
+// Reader значение из хранилища, которое можно читать с любой позиции,
+// не загружая целиком в память.
+type Reader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	// Size возвращает размер значения в байтах.
+	Size() int64
+}
+
+// Writer потоковая запись значения в хранилище.
+type Writer interface {
+	io.Writer
+	// Commit сохраняет записанное значение под ключом, в том числе после
+	// начала закрытия хранилища.
+	Commit() error
+	// Abort отменяет запись. После Commit ничего не делает.
+	Abort()
 }
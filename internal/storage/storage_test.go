@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStreaming проверяет потоковые чтение и запись хранилища.
+func testStreaming(t *testing.T, store Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	w, err := store.Create(ctx, "key1")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+
+	// До Commit значения нет
+	_, err = store.Open(ctx, "key1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, w.Commit())
+	assert.Equal(t, 1, store.Size())
+	w.Abort()
+
+	r, err := store.Open(ctx, "key1")
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, int64(12), r.Size())
+	buf := make([]byte, 5)
+	_, err = r.ReadAt(buf, 7)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+	_, err = r.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(rest))
+
+	// Отмененная запись не меняет хранилище
+	w, err = store.Create(ctx, "key2")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	w.Abort()
+	assert.ErrorIs(t, w.Commit(), os.ErrClosed)
+	_, err = store.Open(ctx, "key2")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, 1, store.Size())
+
+	// Close дожидается записи, начатой до закрытия, и она сохраняется
+	w, err = store.Create(ctx, "key3")
+	require.NoError(t, err)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		assert.NoError(t, store.Close())
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the started write finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = w.Write([]byte("late"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+	<-closed
+	r, err = store.Open(ctx, "key3")
+	require.NoError(t, err)
+	r.Close()
+	_, err = store.Create(ctx, "key4")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+// testList проверяет перечисление значений хранилища.
+func testList(t *testing.T, store Storage) {
+	t.Helper()
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	require.NoError(t, store.Set(ctx, "example.com/b.jpg", []byte("original")))
+	require.NoError(t, store.Set(ctx, "example.com/a.jpg#fill:10:10", []byte("variant")))
+	require.NoError(t, store.Set(ctx, "", []byte("empty")))
+
+	entries, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+		assert.True(t, entry.ModTime.After(before), entry.Key)
+	}
+	assert.Equal(t, []string{"", "example.com/a.jpg#fill:10:10", "example.com/b.jpg"}, keys)
+	assert.Equal(t, int64(7), entries[1].Size)
+
+	entry, err := store.Stat(ctx, entries[1].Key)
+	require.NoError(t, err)
+	assert.Equal(t, entries[1].Key, entry.Key)
+	assert.Equal(t, entries[1].Size, entry.Size)
+	assert.True(t, entries[1].ModTime.Equal(entry.ModTime))
+
+	require.NoError(t, store.Delete(ctx, entries[1].Key))
+	entries, err = store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	_, err = store.Stat(ctx, "example.com/a.jpg#fill:10:10")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,21 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	_, err := Setup(context.Background(), "jaeger", "", 1)
+	assert.ErrorContains(t, err, `unknown trace exporter: "jaeger"`)
+
+	for _, exporter := range []string{ExporterNone, ExporterStdout, ExporterOTLP} {
+		shutdown, err := Setup(context.Background(), exporter, "http://127.0.0.1:4318", 1)
+		require.NoError(t, err, exporter)
+		// Экспортер OTLP не подключается к приемнику, пока нет трасс
+		assert.NoError(t, shutdown(context.Background()), exporter)
+	}
+}
@@ -0,0 +1,168 @@
+// Package workpool ограничивает число одновременно выполняемых тяжелых задач.
+package workpool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"imageproxy/internal/metrics"
+)
+
+var (
+	// ErrQueueFull очередь задач заполнена.
+	ErrQueueFull = errors.New("render queue is full")
+	// ErrQueueTimeout задача не дождалась свободного исполнителя.
+	ErrQueueTimeout = errors.New("render queue timeout")
+)
+
+// Stats состояние пула.
+type Stats struct {
+	Workers    int `json:"workers"`
+	Busy       int `json:"busy"`
+	Queued     int `json:"queued"`
+	QueueDepth int `json:"queueDepth"`
+	// Waited число задач, прошедших через очередь, WaitTime - их суммарное
+	// время ожидания.
+	Waited   uint64        `json:"waited"`
+	WaitTime time.Duration `json:"waitTime"`
+	Rejected uint64        `json:"rejected"`
+	TimedOut uint64        `json:"timedOut"`
+}
+
+// Pool пул из фиксированного числа исполнителей с ограниченной очередью.
+// Задачи выполняются в горутине вызывающего, пул лишь ограничивает их число.
+// Освободившийся исполнитель передается первой задаче в очереди, новые
+// задачи не обгоняют ожидающих. Состояние пула публикуется в метриках
+// render_*; в процессе ожидается один пул.
+type Pool struct {
+	workers int
+	depth   int
+	timeout time.Duration
+
+	mu       sync.Mutex
+	busy     int
+	waiters  list.List
+	waited   uint64
+	waitTime time.Duration
+	rejected uint64
+	timedOut uint64
+}
+
+// New создает пул из workers исполнителей с очередью не больше queueDepth
+// задач, каждая из которых ждет исполнителя не дольше timeout.
+func New(workers, queueDepth int, timeout time.Duration) *Pool {
+	p := &Pool{
+		workers: max(workers, 1),
+		depth:   queueDepth,
+		timeout: timeout,
+	}
+	metrics.RenderWorkers.Set(float64(p.workers))
+	metrics.RenderQueueDepth.Set(float64(queueDepth))
+	return p
+}
+
+// Do выполняет fn, когда освободится исполнитель. Если очередь заполнена,
+// сразу возвращает ErrQueueFull, если исполнитель не освободился за время
+// ожидания - ErrQueueTimeout.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+	metrics.RenderWorkersBusy.Inc()
+	defer func() {
+		metrics.RenderWorkersBusy.Dec()
+		p.release()
+	}()
+	fn()
+	return nil
+}
+
+func (p *Pool) acquire(ctx context.Context) error {
+	p.mu.Lock()
+	if p.busy < p.workers && p.waiters.Len() == 0 {
+		p.busy++
+		p.mu.Unlock()
+		return nil
+	}
+	if p.waiters.Len() >= p.depth {
+		p.rejected++
+		p.mu.Unlock()
+		metrics.RenderQueueRejected.Inc()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	elem := p.waiters.PushBack(ready)
+	p.mu.Unlock()
+	metrics.RenderQueueLength.Inc()
+	defer metrics.RenderQueueLength.Dec()
+
+	start := time.Now()
+	defer func() {
+		wait := time.Since(start)
+		p.mu.Lock()
+		p.waited++
+		p.waitTime += wait
+		p.mu.Unlock()
+		metrics.RenderQueueWait.Observe(wait.Seconds())
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.mu.Lock()
+	select {
+	case <-ready:
+		// Исполнитель передан одновременно с отменой, отдаем его следующему
+		p.mu.Unlock()
+		p.release()
+		return err
+	default:
+	}
+	p.waiters.Remove(elem)
+	if errors.Is(err, ErrQueueTimeout) {
+		p.timedOut++
+		metrics.RenderQueueTimeouts.Inc()
+	}
+	p.mu.Unlock()
+	return err
+}
+
+// release передает исполнителя первой задаче в очереди или освобождает его.
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem := p.waiters.Front(); elem != nil {
+		p.waiters.Remove(elem)
+		close(elem.Value.(chan struct{}))
+		return
+	}
+	p.busy--
+}
+
+// Stats возвращает текущее состояние пула.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		Workers:    p.workers,
+		Busy:       p.busy,
+		Queued:     p.waiters.Len(),
+		QueueDepth: p.depth,
+		Waited:     p.waited,
+		WaitTime:   p.waitTime,
+		Rejected:   p.rejected,
+		TimedOut:   p.timedOut,
+	}
+}
@@ -0,0 +1,138 @@
+package workpool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/metrics"
+)
+
+// occupy занимает всех исполнителей пула до закрытия release.
+func occupy(t *testing.T, p *Pool, release chan struct{}) *sync.WaitGroup {
+	t.Helper()
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	for i := 0; i < p.Stats().Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Do(context.Background(), func() {
+				started <- struct{}{}
+				<-release
+			})
+		}()
+		<-started
+	}
+	return &wg
+}
+
+func TestPool_QueueFull(t *testing.T) {
+	p := New(2, 1, time.Second)
+	release := make(chan struct{})
+	wg := occupy(t, p, release)
+
+	queued := make(chan error)
+	go func() { queued <- p.Do(context.Background(), func() {}) }()
+	require.Eventually(t, func() bool { return p.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, p.Do(context.Background(), func() {}), ErrQueueFull)
+
+	close(release)
+	require.NoError(t, <-queued)
+	wg.Wait()
+
+	stats := p.Stats()
+	assert.Equal(t, Stats{Workers: 2, QueueDepth: 1, Waited: 1, WaitTime: stats.WaitTime, Rejected: 1}, stats)
+	assert.Positive(t, stats.WaitTime)
+}
+
+func TestPool_QueueTimeout(t *testing.T) {
+	p := New(1, 5, 10*time.Millisecond)
+	release := make(chan struct{})
+	wg := occupy(t, p, release)
+
+	ran := false
+	assert.ErrorIs(t, p.Do(context.Background(), func() { ran = true }), ErrQueueTimeout)
+	assert.False(t, ran)
+	assert.Equal(t, uint64(1), p.Stats().TimedOut)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.Do(ctx, func() {}), context.Canceled)
+
+	close(release)
+	wg.Wait()
+	assert.NoError(t, p.Do(context.Background(), func() { ran = true }))
+	assert.True(t, ran)
+}
+
+func TestPool_FIFO(t *testing.T) {
+	p := New(1, 5, time.Second)
+	release := make(chan struct{})
+	wg := occupy(t, p, release)
+
+	var mu sync.Mutex
+	var order []string
+	gate := make(chan struct{})
+	run := func(name string, block bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, p.Do(context.Background(), func() {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				if block {
+					<-gate
+				}
+			}))
+		}()
+	}
+	run("first", true)
+	require.Eventually(t, func() bool { return p.Stats().Queued == 1 }, time.Second, time.Millisecond)
+	run("second", false)
+	require.Eventually(t, func() bool { return p.Stats().Queued == 2 }, time.Second, time.Millisecond)
+
+	// Освободившийся исполнитель достается первой задаче в очереди
+	close(release)
+	require.Eventually(t, func() bool { return p.Stats().Queued == 1 }, time.Second, time.Millisecond)
+	// Новая задача встает в очередь за ожидающей, а не занимает исполнителя
+	run("third", false)
+	require.Eventually(t, func() bool { return p.Stats().Queued == 2 }, time.Second, time.Millisecond)
+
+	close(gate)
+	wg.Wait()
+	assert.Equal(t, []string{"first", "second", "third"}, order)
+	assert.Equal(t, 0, p.Stats().Busy)
+}
+
+func TestPool_Metrics(t *testing.T) {
+	p := New(3, 0, time.Second)
+	release := make(chan struct{})
+	wg := occupy(t, p, release)
+	assert.ErrorIs(t, p.Do(context.Background(), func() {}), ErrQueueFull)
+
+	body := scrape(t)
+	assert.Contains(t, body, "imageproxy_render_workers 3\n")
+	assert.Contains(t, body, "imageproxy_render_workers_busy 3\n")
+	assert.Contains(t, body, "imageproxy_render_queue_depth 0\n")
+	assert.Contains(t, body, "imageproxy_render_queue_rejected_total")
+
+	close(release)
+	wg.Wait()
+	assert.Contains(t, scrape(t), "imageproxy_render_workers_busy 0\n")
+}
+
+// scrape возвращает метрики из /metrics.
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Body.String()
+}
@@ -0,0 +1,104 @@
+// Package urlsign подписывает и проверяет URL запросов к imageproxy.
+//
+// Подпись HMAC-SHA256 вычисляется по идентификатору ключа, сроку действия
+// и пути запроса (операции и URL изображения) и передается в параметрах
+// запроса:
+//
+//	/p/rs:fill:300:200/plain/example.com/1.jpg?k=main&e=1767225600&s=...
+//
+// Сервер принимает подписи любым ключом из набора, поэтому ключи можно
+// менять без простоя: новый ключ добавляется в набор, клиенты переходят
+// на него, после чего старый ключ удаляется.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Параметры запроса с подписью.
+const (
+	KeyParam       = "k"
+	ExpiresParam   = "e"
+	SignatureParam = "s"
+)
+
+var (
+	// ErrMissingSignature запрос без подписи.
+	ErrMissingSignature = errors.New("missing signature")
+	// ErrInvalidSignature подпись не совпадает или ключ неизвестен.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired срок действия подписи истек.
+	ErrExpired = errors.New("signature expired")
+)
+
+// KeySet набор ключей подписи по идентификаторам.
+type KeySet map[string][]byte
+
+// ParseKey декодирует ключ подписи id из шестнадцатеричной записи.
+func ParseKey(id, hexKey string) ([]byte, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("signing key %q must be a non-empty hex string", id)
+	}
+	return key, nil
+}
+
+// Sign возвращает path с параметрами подписи ключом keyID. Нулевой expires
+// означает бессрочную подпись.
+func Sign(path, keyID string, key []byte, expires time.Time) string {
+	var exp int64
+	if !expires.IsZero() {
+		exp = expires.Unix()
+	}
+	query := url.Values{}
+	query.Set(KeyParam, keyID)
+	if exp > 0 {
+		query.Set(ExpiresParam, strconv.FormatInt(exp, 10))
+	}
+	query.Set(SignatureParam, signature(path, keyID, key, exp))
+	u := url.URL{Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// Verify проверяет подпись пути path из параметров query и возвращает
+// идентификатор ключа, которым подписан запрос.
+func (ks KeySet) Verify(path string, query url.Values, now time.Time) (string, error) {
+	sig := query.Get(SignatureParam)
+	if sig == "" {
+		return "", ErrMissingSignature
+	}
+	keyID := query.Get(KeyParam)
+	key, ok := ks[keyID]
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+
+	var exp int64
+	if e := query.Get(ExpiresParam); e != "" {
+		var err error
+		if exp, err = strconv.ParseInt(e, 10, 64); err != nil || exp <= 0 {
+			return "", ErrInvalidSignature
+		}
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(path, keyID, key, exp))) {
+		return "", ErrInvalidSignature
+	}
+	if exp > 0 && now.Unix() > exp {
+		return "", ErrExpired
+	}
+	return keyID, nil
+}
+
+func signature(path, keyID string, key []byte, exp int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyID + "\n" + strconv.FormatInt(exp, 10) + "\n" + path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package urlsign
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPath = "/p/rs:fill:300:200/plain/localhost:8080/images/1.jpg"
+
+func parse(t *testing.T, signed string) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	return u.Path, u.Query()
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("new", "a0b0c0")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xa0, 0xb0, 0xc0}, key)
+
+	for _, hexKey := range []string{"xyz", "", "012"} {
+		_, err := ParseKey("old", hexKey)
+		assert.Error(t, err, hexKey)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	keys := KeySet{"old": []byte("old secret"), "new": []byte("new secret")}
+	now := time.Unix(1700000000, 0)
+
+	// Подписи обоими ключами набора принимаются
+	for id, key := range keys {
+		path, query := parse(t, Sign(testPath, id, key, time.Time{}))
+		assert.Equal(t, testPath, path)
+		keyID, err := keys.Verify(path, query, now)
+		require.NoError(t, err)
+		assert.Equal(t, id, keyID)
+	}
+
+	path, query := parse(t, Sign(testPath, "new", keys["new"], now.Add(time.Minute)))
+	_, err := keys.Verify(path, query, now)
+	require.NoError(t, err)
+	_, err = keys.Verify(path, query, now.Add(2*time.Minute))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// Продление срока действия ломает подпись
+	query.Set(ExpiresParam, "1800000000")
+	_, err = keys.Verify(path, query, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	path, query = parse(t, Sign(testPath, "new", keys["new"], time.Time{}))
+	_, err = keys.Verify("/p/rs:fill:3000:2000/plain/localhost:8080/images/1.jpg", query, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, query = parse(t, Sign(testPath, "retired", []byte("retired secret"), time.Time{}))
+	_, err = keys.Verify(path, query, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = keys.Verify(path, url.Values{}, now)
+	assert.ErrorIs(t, err, ErrMissingSignature)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"imageproxy/internal/processor"
+)
+
+// errAmbiguousMatch возвращается, если задано больше одного условия отбора.
+var errAmbiguousMatch = errors.New("only one of url, prefix and glob may be set")
+
+// cacheEntry запись кэша в ответах API администрирования.
+type cacheEntry struct {
+	processor.CacheEntry
+	// Age возраст записи в секундах.
+	Age int64 `json:"age"`
+}
+
+// requireAdmin пропускает к next запросы с токеном администратора в
+// заголовке Authorization. Пустой токен отключает API.
+func requireAdmin(token string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="imageproxy-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// adminHandler возвращает API администрирования кэша:
+//
+//	GET  /admin/cache              записи, можно отобрать по url, prefix или glob
+//	GET  /admin/cache/entry?key=   одна запись
+//	POST /admin/cache/purge        удаление записей по url, prefix или glob
+//	POST /admin/cache/clear        удаление всех записей
+func adminHandler(imgProcessor *processor.ImageProcessor) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/cache", func(w http.ResponseWriter, r *http.Request) {
+		match, err := urlMatcher(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := imgProcessor.CacheEntries(r.Context())
+		if err != nil {
+			adminError(w, r, err)
+			return
+		}
+		now := time.Now()
+		list := make([]cacheEntry, 0, len(entries))
+		var size int64
+		for _, entry := range entries {
+			if match != nil && !match(entry.URL) {
+				continue
+			}
+			list = append(list, newCacheEntry(entry, now))
+			size += entry.Size
+		}
+		writeJSON(w, r, map[string]any{"entries": list, "count": len(list), "size": size})
+	})
+
+	mux.HandleFunc("GET /admin/cache/entry", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		entry, ok, err := imgProcessor.GetCacheEntry(r.Context(), key)
+		if err != nil {
+			adminError(w, r, err)
+			return
+		}
+		if !ok {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, newCacheEntry(*entry, time.Now()))
+	})
+
+	mux.HandleFunc("POST /admin/cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		match, err := urlMatcher(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if match == nil {
+			http.Error(w, "one of url, prefix and glob is required", http.StatusBadRequest)
+			return
+		}
+		purge(w, r, imgProcessor, match)
+	})
+
+	mux.HandleFunc("POST /admin/cache/clear", func(w http.ResponseWriter, r *http.Request) {
+		purge(w, r, imgProcessor, func(string) bool { return true })
+	})
+
+	return mux
+}
+
+// purge удаляет записи изображений, URL которых подходят под match, и
+// отвечает числом удаленных записей.
+func purge(w http.ResponseWriter, r *http.Request, imgProcessor *processor.ImageProcessor, match func(string) bool) {
+	purged, err := imgProcessor.Purge(r.Context(), match)
+	if err != nil {
+		adminError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Cache purged", "query", r.URL.RawQuery, "entries", purged)
+	writeJSON(w, r, map[string]int{"purged": purged})
+}
+
+// urlMatcher возвращает условие отбора по URL изображения из параметров
+// url (точное совпадение), prefix или glob (шаблон path.Match). Если ни
+// один не задан, возвращает nil. URL указывается без схемы, как в пути
+// запроса к сервису.
+func urlMatcher(query url.Values) (func(string) bool, error) {
+	var match func(string) bool
+	for _, name := range []string{"url", "prefix", "glob"} {
+		if !query.Has(name) {
+			continue
+		}
+		if match != nil {
+			return nil, errAmbiguousMatch
+		}
+		value := trimScheme(query.Get(name))
+		switch name {
+		case "url":
+			match = func(u string) bool { return u == value }
+		case "prefix":
+			match = func(u string) bool { return strings.HasPrefix(u, value) }
+		case "glob":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, err
+			}
+			match = func(u string) bool {
+				ok, _ := path.Match(value, u)
+				return ok
+			}
+		}
+	}
+	return match, nil
+}
+
+// trimScheme отбрасывает схему: изображения загружаются по http, и в
+// ключах кэша схемы нет.
+func trimScheme(u string) string {
+	if rest, ok := strings.CutPrefix(u, "http://"); ok {
+		return rest
+	}
+	return strings.TrimPrefix(u, "https://")
+}
+
+func newCacheEntry(entry processor.CacheEntry, now time.Time) cacheEntry {
+	return cacheEntry{CacheEntry: entry, Age: int64(now.Sub(entry.ModTime).Seconds())}
+}
+
+// adminError отвечает 500 и передает ошибку в журнал доступа.
+func adminError(w http.ResponseWriter, r *http.Request, err error) {
+	annotate(r.Context()).err = err
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.WarnContext(r.Context(), "Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/cache"
+	"imageproxy/internal/processor"
+	Storage "imageproxy/internal/storage"
+)
+
+const testAdminToken = "0123456789abcdef"
+
+func TestRequireAdmin(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := func(token, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, "/admin/cache", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		requireAdmin(token, next)(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, request("", "Bearer "), "API is disabled without token")
+	assert.Equal(t, http.StatusUnauthorized, request(testAdminToken, ""))
+	assert.Equal(t, http.StatusUnauthorized, request(testAdminToken, "Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, request(testAdminToken, "Basic "+testAdminToken))
+	assert.Equal(t, http.StatusNoContent, request(testAdminToken, "Bearer "+testAdminToken))
+}
+
+func TestURLMatcher(t *testing.T) {
+	tests := []struct {
+		query string
+		url   string
+		want  bool
+	}{
+		{"url=example.com/a.jpg", "example.com/a.jpg", true},
+		{"url=http://example.com/a.jpg", "example.com/a.jpg", true},
+		{"url=example.com/a.jpg", "example.com/a.jpg.bak", false},
+		{"prefix=example.com/products/", "example.com/products/1/a.jpg", true},
+		{"prefix=example.com/products/", "example.com/a.jpg", false},
+		{"glob=example.com/*/a.jpg", "example.com/products/a.jpg", true},
+		{"glob=example.com/*.jpg", "example.com/products/a.jpg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			match, err := urlMatcher(parseQuery(t, tt.query))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, match(tt.url))
+		})
+	}
+
+	match, err := urlMatcher(parseQuery(t, ""))
+	require.NoError(t, err)
+	assert.Nil(t, match)
+	_, err = urlMatcher(parseQuery(t, "url=a&prefix=b"))
+	assert.ErrorIs(t, err, errAmbiguousMatch)
+	_, err = urlMatcher(parseQuery(t, "glob=["))
+	assert.Error(t, err)
+}
+
+func TestAdminHandler(t *testing.T) {
+	ctx := context.Background()
+	first, _ := newTestImageOrigin(t)
+	second, _ := newTestImageOrigin(t)
+	store := Storage.NewMemoryStorage()
+	p := processor.NewImageProcessor(cache.NewLRUCache("originals", 10, Storage.WithPrefix(store, "originals/")),
+		cache.NewLRUCache("variants", 10, Storage.WithPrefix(store, "variants/")), processor.DefaultConfig())
+	for _, imageURL := range []string{first, second} {
+		_, err := p.ProcessImage(ctx, imageURL, processor.Options{Operations: []processor.Operation{
+			processor.Resize{Type: processor.ResizeForce, Width: 16, Height: 16},
+		}})
+		require.NoError(t, err)
+	}
+	handler := adminHandler(p)
+	call := func(method, target string, v any) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		if v != nil && w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	var list struct {
+		Entries []cacheEntry `json:"entries"`
+		Count   int          `json:"count"`
+		Size    int64        `json:"size"`
+	}
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/cache", &list))
+	assert.Equal(t, 4, list.Count)
+	assert.Positive(t, list.Size)
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/cache?url="+first, &list))
+	require.Equal(t, 2, list.Count)
+
+	var entry cacheEntry
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/cache/entry?key="+first, &entry))
+	assert.Equal(t, processor.EntryOriginal, entry.Kind)
+	assert.Equal(t, first, entry.URL)
+	assert.GreaterOrEqual(t, entry.Age, int64(0))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/admin/cache/entry?key=missing", nil))
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodGet, "/admin/cache/entry", nil))
+
+	var result struct {
+		Purged int `json:"purged"`
+	}
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/cache/purge", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, call(http.MethodGet, "/admin/cache/purge?url="+first, nil))
+	require.Equal(t, http.StatusOK, call(http.MethodPost, "/admin/cache/purge?url="+first, &result))
+	assert.Equal(t, 2, result.Purged)
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/cache", &list))
+	assert.Equal(t, 2, list.Count)
+
+	require.Equal(t, http.StatusOK, call(http.MethodPost, "/admin/cache/clear", &result))
+	assert.Equal(t, 2, result.Purged)
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/admin/cache", &list))
+	assert.Zero(t, list.Count)
+}
+
+func parseQuery(t *testing.T, query string) url.Values {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	require.NoError(t, err)
+	return values
+}
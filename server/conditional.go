@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"imageproxy/internal/config"
+)
+
+// cacheControl возвращает политику кэширования ответа. Подписанные URL
+// не меняют содержимое, поэтому для них задается отдельная политика.
+func cacheControl(r *http.Request, cfg config.Server) string {
+	if signingKeyID(r.Context()) != "" {
+		return cfg.SignedCacheControl
+	}
+	return cfg.CacheControl
+}
+
+// setValidators добавляет к ответу валидаторы и политику кэширования.
+func setValidators(h http.Header, etag string, lastModified time.Time, cacheControl string) {
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+}
+
+// notModified проверяет условия запроса по RFC 9110: If-None-Match, а при
+// его отсутствии If-Modified-Since. Возвращает true, если клиенту можно
+// ответить 304.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatch(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// etagMatch сравнивает список If-None-Match с etag слабым сравнением.
+func etagMatch(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified отвечает 304 с валидаторами и политикой кэширования.
+func writeNotModified(w http.ResponseWriter, etag string, lastModified time.Time, cacheControl string) {
+	setValidators(w.Header(), etag, lastModified, cacheControl)
+	w.WriteHeader(http.StatusNotModified)
+}
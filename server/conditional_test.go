@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/cache"
+	"imageproxy/internal/config"
+	"imageproxy/internal/processor"
+	Storage "imageproxy/internal/storage"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"No conditions", http.MethodGet, nil, false},
+		{"ETag matches", http.MethodGet, map[string]string{"If-None-Match": etag}, true},
+		{"Weak ETag matches", http.MethodGet, map[string]string{"If-None-Match": `W/"abc123"`}, true},
+		{"ETag in list", http.MethodGet, map[string]string{"If-None-Match": `"other", "abc123"`}, true},
+		{"Any ETag", http.MethodGet, map[string]string{"If-None-Match": "*"}, true},
+		{"ETag differs", http.MethodGet, map[string]string{"If-None-Match": `"other"`}, false},
+		{"HEAD", http.MethodHead, map[string]string{"If-None-Match": etag}, true},
+		{"POST", http.MethodPost, map[string]string{"If-None-Match": etag}, false},
+		{"Not modified since", http.MethodGet, map[string]string{
+			"If-Modified-Since": lastModified.Format(http.TimeFormat),
+		}, true},
+		{"Modified since", http.MethodGet, map[string]string{
+			"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat),
+		}, false},
+		{"Invalid date", http.MethodGet, map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{"If-None-Match takes precedence", http.MethodGet, map[string]string{
+			"If-None-Match":     `"other"`,
+			"If-Modified-Since": lastModified.Format(http.TimeFormat),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/fill/100/100/example.com/img.jpg", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, notModified(r, etag, lastModified))
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cfg := config.Server{CacheControl: "public, max-age=60", SignedCacheControl: "public, immutable"}
+	r := httptest.NewRequest(http.MethodGet, "/fill/100/100/example.com/img.jpg", nil)
+	assert.Equal(t, "public, max-age=60", cacheControl(r, cfg))
+
+	r = r.WithContext(context.WithValue(r.Context(), keyIDKey{}, "main"))
+	assert.Equal(t, "public, immutable", cacheControl(r, cfg))
+}
+
+func TestWriteNotModified(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w := httptest.NewRecorder()
+	writeNotModified(w, `"abc123"`, lastModified, "")
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"abc123"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", w.Header().Get("Last-Modified"))
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Body.String())
+}
+
+// newTestImageOrigin поднимает источник с одним JPEG и возвращает URL
+// изображения без схемы и счетчик запросов к источнику.
+func newTestImageOrigin(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil))
+	fetches := &atomic.Int32{}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(buf.Bytes())
+	}))
+	t.Cleanup(origin.Close)
+	return strings.TrimPrefix(origin.URL, "http://") + "/image.jpg", fetches
+}
+
+// newImageServer поднимает источник с одним JPEG и возвращает обработчик
+// serveImage и счетчик запросов к источнику.
+func newImageServer(t *testing.T) (http.HandlerFunc, *atomic.Int32) {
+	t.Helper()
+	url, fetches := newTestImageOrigin(t)
+	store := Storage.NewMemoryStorage()
+	p := processor.NewImageProcessor(cache.NewLRUCache("originals", 10, Storage.WithPrefix(store, "originals/")),
+		cache.NewLRUCache("variants", 10, Storage.WithPrefix(store, "variants/")), processor.DefaultConfig())
+	opts := processor.Options{Operations: []processor.Operation{
+		processor.Resize{Type: processor.ResizeForce, Width: 32, Height: 32},
+	}}
+	cfg := config.Server{CacheControl: "public, max-age=60", DebugHeaders: true}
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveImage(w, r, p, url, opts, cfg)
+	}, fetches
+}
+
+func TestServeImage(t *testing.T) {
+	handler, fetches := newImageServer(t)
+	get := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/fill/32/32/image.jpg", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	full := get(http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, full.Code)
+	etag := full.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "image/jpeg", full.Header().Get("Content-Type"))
+	assert.Equal(t, "bytes", full.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "public, max-age=60", full.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, full.Header().Get("Last-Modified"))
+	// Новый вариант кодируется в ответ и в кэш одновременно, поэтому размер
+	// заранее неизвестен, а время кодирования приходит в трейлере
+	res := full.Result()
+	assert.Empty(t, res.Header.Get("Content-Length"))
+	assert.Empty(t, res.Header.Get("Server-Timing"))
+	assert.Contains(t, res.Trailer.Get("Server-Timing"), "encode;dur=")
+	_, _, err := image.Decode(bytes.NewReader(full.Body.Bytes()))
+	require.NoError(t, err)
+
+	t.Run("Cached", func(t *testing.T) {
+		w := get(http.MethodGet, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, strconv.Itoa(full.Body.Len()), w.Header().Get("Content-Length"))
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, full.Body.Bytes(), w.Body.Bytes())
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		w := get(http.MethodGet, map[string]string{"Range": "bytes=0-9"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, fmt.Sprintf("bytes 0-9/%d", full.Body.Len()), w.Header().Get("Content-Range"))
+		assert.Equal(t, full.Body.Bytes()[:10], w.Body.Bytes())
+	})
+
+	t.Run("Range with stale If-Range", func(t *testing.T) {
+		w := get(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, full.Body.Len(), w.Body.Len())
+	})
+
+	t.Run("Unsatisfiable range", func(t *testing.T) {
+		w := get(http.MethodGet, map[string]string{"Range": fmt.Sprintf("bytes=%d-", full.Body.Len())})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		w := get(http.MethodGet, map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("HEAD from cache", func(t *testing.T) {
+		w := get(http.MethodHead, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, strconv.Itoa(full.Body.Len()), w.Header().Get("Content-Length"))
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Body.String())
+
+		w = get(http.MethodHead, map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	assert.Equal(t, int32(1), fetches.Load(), "origin is fetched once")
+}
+
+func TestServeImage_HeadMiss(t *testing.T) {
+	handler, fetches := newImageServer(t)
+
+	// Варианта нет в кэше: HEAD обрабатывает изображение, чтобы узнать размер
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodHead, "/fill/32/32/image.jpg", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Content-Length"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestServeImage_NotModifiedMiss(t *testing.T) {
+	handler, _ := newImageServer(t)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/fill/32/32/image.jpg", nil))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// ETag не зависит от кэша: вариант, обработанный заново, тоже
+	// получает 304
+	handler, fetches := newImageServer(t)
+	r := httptest.NewRequest(http.MethodGet, "/fill/32/32/image.jpg", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), fetches.Load())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"imageproxy/internal/processor"
+)
+
+// Имена кэшей в заголовке Cache-Status.
+const (
+	originalsCacheName = "imageproxy-originals"
+	variantsCacheName  = "imageproxy-variants"
+)
+
+// setDebugHeaders добавляет заголовки для отладки из инструментов
+// разработчика браузера: длительности стадий, статус кэшей и размеры
+// исходного изображения.
+func setDebugHeaders(h http.Header, result *processor.Result) {
+	if len(result.Timings) > 0 {
+		h.Set("Server-Timing", serverTiming(result.Timings))
+	}
+	h.Set("Cache-Status", cacheStatus(result))
+	if result.OriginalWidth > 0 && result.OriginalHeight > 0 {
+		h.Set("X-Original-Size", fmt.Sprintf("%dx%d", result.OriginalWidth, result.OriginalHeight))
+	}
+}
+
+// serverTiming записывает длительности стадий в формате Server-Timing
+// в миллисекундах.
+func serverTiming(timings []processor.Timing) string {
+	metrics := make([]string, 0, len(timings))
+	for _, t := range timings {
+		ms := float64(t.Duration) / float64(time.Millisecond)
+		metrics = append(metrics, t.Stage+";dur="+strconv.FormatFloat(ms, 'f', 1, 64))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// cacheStatus записывает результат поиска в кэшах по RFC 9211. Первым
+// указывается кэш, ближайший к источнику, - кэш оригиналов.
+func cacheStatus(result *processor.Result) string {
+	var entries []string
+	if result.OriginalCacheStatus != "" {
+		entries = append(entries, cacheStatusEntry(originalsCacheName, result.OriginalCacheStatus))
+	}
+	return strings.Join(append(entries, cacheStatusEntry(variantsCacheName, result.CacheStatus)), ", ")
+}
+
+func cacheStatusEntry(cache string, status processor.CacheStatus) string {
+	if status == processor.CacheHit {
+		return cache + "; hit"
+	}
+	// Промах: ответ получен от следующего звена и сохранен в кэше
+	return cache + "; fwd=uri-miss; stored"
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"imageproxy/internal/processor"
+)
+
+func TestSetDebugHeaders(t *testing.T) {
+	t.Run("Processed", func(t *testing.T) {
+		h := http.Header{}
+		setDebugHeaders(h, &processor.Result{
+			CacheStatus:         processor.CacheMiss,
+			OriginalCacheStatus: processor.CacheHit,
+			OriginalWidth:       1920,
+			OriginalHeight:      1080,
+			Timings: []processor.Timing{
+				{Stage: processor.TimingCache, Duration: 1500 * time.Microsecond},
+				{Stage: processor.TimingDecode, Duration: 12 * time.Millisecond},
+			},
+		})
+		assert.Equal(t, "cache;dur=1.5, decode;dur=12.0", h.Get("Server-Timing"))
+		assert.Equal(t, "imageproxy-originals; hit, imageproxy-variants; fwd=uri-miss; stored", h.Get("Cache-Status"))
+		assert.Equal(t, "1920x1080", h.Get("X-Original-Size"))
+	})
+
+	t.Run("Variant from cache", func(t *testing.T) {
+		h := http.Header{}
+		setDebugHeaders(h, &processor.Result{CacheStatus: processor.CacheHit})
+		assert.Equal(t, "imageproxy-variants; hit", h.Get("Cache-Status"))
+		assert.Empty(t, h.Get("X-Original-Size"))
+		assert.Empty(t, h.Get("Server-Timing"))
+	})
+}
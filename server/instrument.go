@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"imageproxy/internal/logging"
+	"imageproxy/internal/metrics"
+	"imageproxy/internal/processor"
+)
+
+// requestIDHeader заголовок с идентификатором запроса.
+const requestIDHeader = "X-Request-ID"
+
+// tracer создает spans входящих запросов.
+var tracer = otel.Tracer("imageproxy/server")
+
+// maxRequestIDLength максимальная длина принимаемого от клиента идентификатора.
+const maxRequestIDLength = 128
+
+// statusRecorder запоминает статус и объем ответа.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// ReadFrom передает поток исходному ResponseWriter, чтобы http.ServeContent
+// мог отправить файл через sendfile.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		// Без обертки io.Copy снова вызвал бы ReadFrom
+		n, err = io.Copy(struct{ io.Writer }{r.ResponseWriter}, src)
+	}
+	r.bytes += int(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestLog сведения о запросе для журнала доступа, которые заполняют
+// обработчики.
+type requestLog struct {
+	cacheStatus processor.CacheStatus
+	origin      string
+	err         error
+}
+
+type requestLogKey struct{}
+
+// annotate возвращает сведения о запросе для журнала доступа. Вне
+// instrument сведения никуда не записываются.
+func annotate(ctx context.Context) *requestLog {
+	if info, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return info
+	}
+	return &requestLog{}
+}
+
+// originHost возвращает источник изображения из url без схемы.
+func originHost(url string) string {
+	host, _, _ := strings.Cut(url, "/")
+	return host
+}
+
+// requestID возвращает идентификатор запроса из X-Request-ID или новый,
+// если клиент его не передал или передал недопустимый.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// abortedStatus метка метрик для запросов, обработчик которых прервался
+// паникой, например http.ErrAbortHandler после отправки статуса.
+const abortedStatus = "aborted"
+
+// instrument присваивает запросу идентификатор, начинает span с контекстом
+// трассировки из заголовков, учитывает запросы к next в метриках под именем
+// operation и пишет строку журнала доступа. Прерванный паникой запрос тоже
+// учитывается, после чего паника передается серверу.
+func instrument(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		info := &requestLog{}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String(logging.RequestIDKey, id),
+			))
+		defer span.End()
+		ctx = logging.WithRequestID(context.WithValue(ctx, requestLogKey{}, info), id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered != nil && info.err == nil {
+				info.err = fmt.Errorf("panic: %v", recovered)
+			}
+			record(ctx, operation, r, rec, info, span, time.Since(start), recovered != nil)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		next(rec, r.WithContext(ctx))
+	}
+}
+
+// record учитывает завершенный запрос в метриках, span и журнале доступа.
+func record(ctx context.Context, operation string, r *http.Request, rec *statusRecorder, info *requestLog,
+	span trace.Span, duration time.Duration, aborted bool,
+) {
+	if rec.status == 0 && !aborted {
+		rec.status = http.StatusOK
+	}
+	status := strconv.Itoa(rec.status)
+	if aborted {
+		status = abortedStatus
+	}
+	metrics.RequestsTotal.WithLabelValues(operation, status).Inc()
+	metrics.RequestDuration.WithLabelValues(operation, status).Observe(duration.Seconds())
+	metrics.ResponseBytes.WithLabelValues(operation).Add(float64(rec.bytes))
+
+	if rec.status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+	}
+	if info.cacheStatus != "" {
+		span.SetAttributes(attribute.String("imageproxy.cache", string(info.cacheStatus)))
+	}
+	level := slog.LevelInfo
+	switch {
+	case aborted:
+		level = slog.LevelError
+		span.SetStatus(codes.Error, "handler aborted")
+	case rec.status >= http.StatusInternalServerError:
+		level = slog.LevelError
+		span.SetStatus(codes.Error, http.StatusText(rec.status))
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.status),
+		slog.Int("bytes", rec.bytes),
+		slog.Duration("duration", duration),
+	}
+	if aborted {
+		attrs = append(attrs, slog.Bool("aborted", true))
+	}
+	if info.cacheStatus != "" {
+		attrs = append(attrs, slog.String("cache", string(info.cacheStatus)))
+	}
+	if info.origin != "" {
+		attrs = append(attrs, slog.String("origin", info.origin))
+	}
+	if info.err != nil {
+		attrs = append(attrs, slog.String("error", info.err.Error()))
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	slog.LogAttrs(ctx, level, "Request", attrs...)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"imageproxy/internal/logging"
+	"imageproxy/internal/metrics"
+	"imageproxy/internal/processor"
+)
+
+func TestInstrument(t *testing.T) {
+	handler := instrument("test", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("image"))
+	})
+	for _, path := range []string{"/ok", "/ok", "/missing"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `imageproxy_http_requests_total{operation="test",status="200"} 2`)
+	assert.Contains(t, body, `imageproxy_http_requests_total{operation="test",status="404"} 1`)
+	assert.Contains(t, body, `imageproxy_http_request_duration_seconds_count{operation="test",status="200"} 2`)
+	// Два ответа по 5 байт и текст ошибки с переводом строки
+	assert.Contains(t, body, `imageproxy_http_response_bytes_total{operation="test"} 20`)
+}
+
+func TestInstrument_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+	require.NoError(t, err)
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	var handlerRequestID string
+	handler := instrument("test", func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = logging.RequestID(r.Context())
+		info := annotate(r.Context())
+		info.origin = originHost("example.com/img.jpg")
+		info.cacheStatus = processor.CacheMiss
+		httpError(w, r, errors.New("origin is down"))
+	})
+
+	t.Run("Request ID from header", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/p/img.jpg?s=secret", nil)
+		req.Header.Set(requestIDHeader, "req-42")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		assert.Equal(t, "req-42", rec.Header().Get(requestIDHeader))
+		assert.Equal(t, "req-42", handlerRequestID)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Equal(t, "req-42", entry[logging.RequestIDKey])
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "/p/img.jpg", entry["path"])
+		assert.InDelta(t, http.StatusInternalServerError, entry["status"], 0)
+		assert.Equal(t, "miss", entry["cache"])
+		assert.Equal(t, "example.com", entry["origin"])
+		assert.Equal(t, "origin is down", entry["error"])
+	})
+
+	t.Run("Trace context from header", func(t *testing.T) {
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/p/img.jpg", nil)
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		handler(httptest.NewRecorder(), req)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry["trace_id"])
+	})
+
+	t.Run("Generated request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/p/img.jpg", nil)
+		req.Header.Set(requestIDHeader, "bad id")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		id := rec.Header().Get(requestIDHeader)
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, handlerRequestID)
+	})
+}
+
+func TestInstrument_Abort(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+	require.NoError(t, err)
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	handler := instrument("abort", func(w http.ResponseWriter, r *http.Request) {
+		annotate(r.Context()).err = errors.New("encoder failed")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("part"))
+		panic(http.ErrAbortHandler)
+	})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/p/img.jpg", nil))
+	})
+
+	// Прерванный запрос попадает в журнал и метрики
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, true, entry["aborted"])
+	assert.Equal(t, "encoder failed", entry["error"])
+	assert.InDelta(t, http.StatusOK, entry["status"], 0)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `imageproxy_http_requests_total{operation="abort",status="aborted"} 1`)
+}
+
+// readerFromRecorder отмечает, что ответ записан через ReadFrom.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestStatusRecorder_Forwarding(t *testing.T) {
+	w := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rec := &statusRecorder{ResponseWriter: w}
+
+	n, err := io.Copy(rec, struct{ io.Reader }{strings.NewReader("image")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.True(t, w.readFrom)
+	assert.Equal(t, http.StatusOK, rec.status)
+	assert.Equal(t, 5, rec.bytes)
+
+	// Без ReaderFrom поток копируется обычной записью
+	plain := httptest.NewRecorder()
+	rec = &statusRecorder{ResponseWriter: plain}
+	_, err = rec.ReadFrom(strings.NewReader("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", plain.Body.String())
+	assert.Equal(t, 4, rec.bytes)
+
+	require.NoError(t, http.NewResponseController(rec).Flush())
+	assert.True(t, plain.Flushed)
+	assert.Same(t, plain, rec.Unwrap())
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"imageproxy/internal/config"
+	Storage "imageproxy/internal/storage"
 )
 
 const (
@@ -123,7 +125,11 @@ func startApplication(t *testing.T) {
 	t.Log("Starting application...")
 	os.Setenv("PORT", appPort)
 	os.Setenv("STORAGE_TYPE", "memory")
-	go main()
+	// main разбирает os.Args, в которых go test передает свои флаги
+	cfg, _, err := config.Load(nil, os.LookupEnv)
+	require.NoError(t, err)
+	ImgStorage = Storage.NewMemoryStorage()
+	go RunServer(cfg)
 
 	// Ждем пока приложение станет доступно
 	client := http.Client{Timeout: 1 * time.Second}
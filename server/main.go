@@ -1,38 +1,74 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"imageproxy/internal/cache"
+	"imageproxy/internal/config"
+	"imageproxy/internal/logging"
+	"imageproxy/internal/membudget"
+	"imageproxy/internal/metrics"
 	"imageproxy/internal/processor"
+	"imageproxy/internal/ratelimit"
 	Storage "imageproxy/internal/storage"
+	"imageproxy/internal/tracing"
+	"imageproxy/internal/workpool"
 )
 
-var (
-	CacheCapacity int
-	ImgStorage    Storage.Storage
-)
+var ImgStorage Storage.Storage
+
+// tracingShutdownTimeout время на отправку накопленных трасс при остановке.
+const tracingShutdownTimeout = 5 * time.Second
 
-func RunServer(cacheCapacity int) {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+// logLevel уровень журнала, меняется при перезагрузке конфигурации.
+var logLevel = new(slog.LevelVar)
+
+func RunServer(cfg config.Config) {
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
 	}
 
-	cache := cache.NewLRUCache(CacheCapacity, ImgStorage)
-	processor := processor.NewImageProcessor(cache)
+	// Кэши делят хранилище, префиксы разделяют их ключи
+	originals := cache.NewLRUCache("originals", cfg.Cache.Capacity, Storage.WithPrefix(ImgStorage, "originals/"))
+	variants := cache.NewLRUCache("variants", cfg.Cache.VariantCapacity, Storage.WithPrefix(ImgStorage, "variants/"))
+	// Конфигурация проверена при загрузке
+	processorConfig, _ := cfg.ProcessorConfig()
+	imgProcessor := processor.NewImageProcessor(originals, variants, processorConfig)
+	// Подпись, ограничения частоты, пресеты и параметры обработки
+	// меняются при перезагрузке конфигурации
+	live := newReloader(cfg, func() (config.Config, error) {
+		next, _, err := config.Load(os.Args[1:], os.LookupEnv)
+		return next, err
+	}, imgProcessor.UpdateConfig)
 
-	// Хендлер для тестирования.
-	http.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	// draining выставляется, когда сервер начинает останавливаться
+	var draining atomic.Bool
+	http.HandleFunc("/health", healthHandler(&draining))
+	http.Handle("/metrics", metrics.Handler())
+
+	http.HandleFunc("/fill/", instrument("fill", live.protect(func(w http.ResponseWriter, r *http.Request) {
+		if live.settings().config.Server.PresetsOnly {
+			http.Error(w, errPresetsOnly.Error(), http.StatusForbidden)
+			return
+		}
 
-	http.HandleFunc("/fill/", func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(r.URL.Path, "/")
 		if len(parts) < 5 {
 			http.Error(w, "Invalid URL format", http.StatusBadRequest)
@@ -51,61 +87,264 @@ func RunServer(cacheCapacity int) {
 			return
 		}
 
-		url := strings.Join(parts[4:], "/")
+		opts, urlParts, err := parseLegacyOptions(width, height, parts[4:])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveImage(w, r, imgProcessor, strings.Join(urlParts, "/"), opts, live.settings().config.Server)
+	})))
+
+	http.HandleFunc("/p/", instrument("pipeline", live.protect(func(w http.ResponseWriter, r *http.Request) {
+		if live.settings().config.Server.PresetsOnly {
+			http.Error(w, errPresetsOnly.Error(), http.StatusForbidden)
+			return
+		}
+
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/p/"), "/")
+		opts, urlParts, err := processor.ParsePipeline(segments)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveImage(w, r, imgProcessor, strings.Join(urlParts, "/"), opts, live.settings().config.Server)
+	})))
+
+	http.HandleFunc("/preset/", instrument("preset", live.protect(func(w http.ResponseWriter, r *http.Request) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/preset/"), "/")
+		preset, ok := imgProcessor.Preset(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown preset %q", name), http.StatusNotFound)
+			return
+		}
+
+		opts, urlParts, err := parsePresetOptions(preset, strings.Split(rest, "/"), live.settings().config.Server.PresetsOnly)
+		if errors.Is(err, errPresetsOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveImage(w, r, imgProcessor, strings.Join(urlParts, "/"), opts, live.settings().config.Server)
+	})))
+
+	http.HandleFunc("/info/", instrument("info", live.protect(func(w http.ResponseWriter, r *http.Request) {
+		url := strings.TrimPrefix(r.URL.Path, "/info/")
 		if url == "" {
 			http.Error(w, "URL is required", http.StatusBadRequest)
 			return
 		}
 
-		data, contentType, err := processor.ProcessImage(r.Context(), url, width, height)
+		annotate(r.Context()).origin = originHost(url)
+		info, err := imgProcessor.GetInfo(r.Context(), url)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-		w.WriteHeader(http.StatusOK)
-		if _, err := w.Write(data); err != nil {
-			fmt.Printf("Failed to write response: %v\n", err)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			slog.WarnContext(r.Context(), "Failed to write response", "error", err)
 		}
-	})
+	})))
+
+	// Просмотр и очистка кэша доступны по токену администратора
+	http.HandleFunc("/admin/", instrument("admin", live.admin(adminHandler(imgProcessor))))
 
-	fmt.Printf("Server listening on :%s (cache capacity: %d)\n", port, cacheCapacity)
+	slog.Info("Server listening", "port", cfg.Server.Port, "cacheCapacity", cfg.Cache.Capacity)
 	server := &http.Server{
-		Addr:         ":" + port,
+		Addr:         ":" + strconv.Itoa(cfg.Server.Port),
 		ReadTimeout:  5 * time.Second,   // максимальное время чтения запроса
 		WriteTimeout: 10 * time.Second,  // максимальное время записи ответа
 		IdleTimeout:  120 * time.Second, // максимальное время ожидания следующего запроса
 	}
-	if err := server.ListenAndServe(); err != nil {
-		fmt.Printf("Server error: %v\n", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go live.run(ctx, cfg.File, cfg.Server.ConfigWatchInterval)
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.ListenAndServe() }()
+
+	select {
+	case err := <-serverErr:
+		slog.Error("Server error", "error", err)
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// Повторный сигнал завершает процесс сразу
+	stop()
+
+	slog.Info("Shutting down, draining requests")
+	serverConfig := live.settings().config.Server
+	if err := shutdown(server, &draining, ImgStorage, serverConfig.ShutdownDelay, serverConfig.ShutdownTimeout); err != nil {
+		slog.Error("Shutdown error", "error", err)
+		os.Exit(1)
+	}
+	// Накопленные трассы отправляются до выхода
+	tracingCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
+	slog.Info("Server stopped")
 }
 
-func cacheCapacity() int {
-	cacheCapacity := 5
-	if envCap := os.Getenv("CACHE_CAPACITY"); envCap != "" {
-		if capacity, err := strconv.Atoi(envCap); err == nil {
-			cacheCapacity = capacity
+// serveImage обрабатывает изображение по url и пишет результат в ответ.
+// Вариант из кэша передается потоком из хранилища через http.ServeContent,
+// который обслуживает условные запросы и запросы диапазонов, новый вариант
+// кодируется прямо в ответ. HEAD для варианта из кэша отвечает по
+// метаданным без обработки.
+func serveImage(w http.ResponseWriter, r *http.Request, imgProcessor *processor.ImageProcessor, url string,
+	opts processor.Options, cfg config.Server,
+) {
+	if url == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	info := annotate(r.Context())
+	info.origin = originHost(url)
+	policy := cacheControl(r, cfg)
+	if r.Method == http.MethodHead {
+		if result, ok := imgProcessor.VariantInfo(r.Context(), url, opts); ok {
+			info.cacheStatus = result.CacheStatus
+			writeHead(w, r, result, cfg.DebugHeaders, policy)
+			return
+		}
+	}
+
+	// Новый вариант кодируется в ответ GET по мере обработки. HEAD получает
+	// вариант из памяти, чтобы знать его размер
+	var respond processor.ResponseFunc
+	started := false
+	if r.Method == http.MethodGet {
+		respond = func(result *processor.Result) io.Writer {
+			started = true
+			info.cacheStatus = result.CacheStatus
+			if cfg.DebugHeaders {
+				setDebugHeaders(w.Header(), result)
+			}
+			if notModified(r, result.ETag, result.LastModified) {
+				writeNotModified(w, result.ETag, result.LastModified, policy)
+				return io.Discard
+			}
+			setImageHeaders(w.Header(), result, policy)
+			if cfg.DebugHeaders {
+				// Время кодирования известно только после отправки тела
+				w.Header().Del("Server-Timing")
+				w.Header().Set("Trailer", "Server-Timing")
+			}
+			w.WriteHeader(http.StatusOK)
+			return w
 		}
 	}
-	return cacheCapacity
+	result, err := imgProcessor.StreamImage(r.Context(), url, opts, respond)
+	if started {
+		if err != nil {
+			// Статус уже отправлен, клиент должен увидеть обрыв, а не
+			// усеченное изображение
+			info.err = err
+			panic(http.ErrAbortHandler)
+		}
+		if cfg.DebugHeaders {
+			w.Header().Set("Server-Timing", serverTiming(result.Timings))
+		}
+		return
+	}
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+
+	defer result.Body.Close()
+	info.cacheStatus = result.CacheStatus
+	if cfg.DebugHeaders {
+		setDebugHeaders(w.Header(), result)
+	}
+	setImageHeaders(w.Header(), result, policy)
+	http.ServeContent(w, r, "", result.LastModified, result.Body)
+}
+
+// writeHead отвечает на HEAD по метаданным результата.
+func writeHead(w http.ResponseWriter, r *http.Request, result *processor.Result, debugHeaders bool, policy string) {
+	if debugHeaders {
+		setDebugHeaders(w.Header(), result)
+	}
+	if notModified(r, result.ETag, result.LastModified) {
+		writeNotModified(w, result.ETag, result.LastModified, policy)
+		return
+	}
+	setImageHeaders(w.Header(), result, policy)
+	w.Header().Set("Content-Length", strconv.Itoa(result.Size))
+	w.WriteHeader(http.StatusOK)
+}
+
+// setImageHeaders добавляет к ответу тип, качество, валидаторы и политику
+// кэширования изображения.
+func setImageHeaders(h http.Header, result *processor.Result, cacheControl string) {
+	h.Set("Content-Type", result.ContentType)
+	h.Set("Accept-Ranges", "bytes")
+	if result.Quality > 0 {
+		h.Set("X-Image-Quality", strconv.Itoa(result.Quality))
+	}
+	setValidators(h, result.ETag, result.LastModified, cacheControl)
+}
+
+// httpError отвечает статусом, соответствующим ошибке обработки, и
+// передает ошибку в журнал доступа.
+func httpError(w http.ResponseWriter, r *http.Request, err error) {
+	annotate(r.Context()).err = err
+	status := http.StatusInternalServerError
+	var limitErr *ratelimit.LimitError
+	switch {
+	case errors.Is(err, processor.ErrInvalidOptions):
+		status = http.StatusBadRequest
+	case errors.Is(err, processor.ErrTooLarge), errors.Is(err, processor.ErrOutputTooLarge),
+		errors.Is(err, membudget.ErrExceedsBudget):
+		status = http.StatusUnprocessableEntity
+	case errors.As(err, &limitErr):
+		status = http.StatusTooManyRequests
+		retryAfter := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	case errors.Is(err, workpool.ErrQueueFull), errors.Is(err, workpool.ErrQueueTimeout),
+		errors.Is(err, membudget.ErrTimeout):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
 }
 
 func main() {
-	CacheCapacity = cacheCapacity()
-	var err error
-	if os.Getenv("STORAGE_TYPE") == "memory" {
+	cfg, printConfig, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	level, _ := cfg.LogLevel()
+	logLevel.Set(level)
+	logger, _ := logging.New(os.Stdout, cfg.Log.Format, logLevel)
+	slog.SetDefault(logger)
+
+	if cfg.Storage.Type == config.StorageMemory {
 		ImgStorage = Storage.NewMemoryStorage()
 	} else {
-		ImgStorage, err = Storage.NewFileStorage("./image_cache")
+		ImgStorage, err = Storage.NewFileStorage(cfg.Storage.Dir)
 		if err != nil {
-			fmt.Printf("Failed to initialize file ImgStoragetorage: %v\n", err)
+			slog.Error("Failed to initialize file storage", "error", err)
 			os.Exit(1)
 		}
 	}
 
-	RunServer(cacheCapacity())
+	RunServer(cfg)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+
+	"imageproxy/internal/processor"
+)
+
+// errPresetsOnly запрос произвольных параметров в режиме только пресетов.
+var errPresetsOnly = errors.New("only presets are allowed")
+
+// legacyOrder порядок выполнения операций для /fill/: повороты и отражения
+// до масштабирования, коррекция после него.
+var legacyOrder = map[string]int{
+	"rot": 0, "fh": 1, "fv": 2, "tp": 3, "tv": 4,
+	"rs": 5,
+	"br": 6, "co": 7, "ga": 8, "sa": 9, "gs": 10, "inv": 11, "bl": 12, "sh": 13,
+}
+
+// parseLegacyOptions переводит запрос /fill/{width}/{height}/... в конвейер:
+// параметры вида name:value из начала segments разбираются как в /p/,
+// а операции упорядочиваются так же, как до появления конвейера.
+// Возвращает оставшиеся сегменты, из которых состоит URL изображения.
+func parseLegacyOptions(width, height int, segments []string) (processor.Options, []string, error) {
+	opts, rest, err := parseOptionPrefix(segments)
+	if err != nil {
+		return opts, nil, err
+	}
+	resize := processor.Resize{Type: processor.ResizeForce, Width: width, Height: height}
+	opts.Operations = append(opts.Operations, resize)
+	slices.SortStableFunc(opts.Operations, func(a, b processor.Operation) int {
+		return cmp.Compare(legacyOrder[a.Name()], legacyOrder[b.Name()])
+	})
+	return opts, rest, nil
+}
+
+// parsePresetOptions накладывает на пресет параметры вида name:value из
+// начала segments. После параметров может стоять сегмент plain.
+// Возвращает оставшиеся сегменты, из которых состоит URL изображения.
+func parsePresetOptions(preset processor.Options, segments []string, presetsOnly bool) (processor.Options, []string, error) {
+	overrides, rest, err := parseOptionPrefix(segments)
+	if err != nil {
+		return preset, nil, err
+	}
+	if len(rest) < len(segments) && presetsOnly {
+		return preset, nil, errPresetsOnly
+	}
+	if len(rest) > 0 && rest[0] == "plain" {
+		rest = rest[1:]
+	}
+	return preset.Override(overrides), rest, nil
+}
+
+// parseOptionPrefix разбирает параметры вида name:value из начала segments.
+func parseOptionPrefix(segments []string) (processor.Options, []string, error) {
+	n := 0
+	for n < len(segments) {
+		name, _, ok := strings.Cut(segments[n], ":")
+		if !ok || !processor.IsOptionName(name) {
+			break
+		}
+		n++
+	}
+
+	opts, _, err := processor.ParsePipeline(append(segments[:n:n], "plain"))
+	if err != nil {
+		return opts, nil, err
+	}
+	return opts, segments[n:], nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/processor"
+)
+
+func TestParseLegacyOptions(t *testing.T) {
+	segments := []string{"quality:60", "progressive:true", "maxbytes:30000", "blur:1.5", "grayscale:true", "rotate:90", "localhost:8080", "images", "1.jpg"}
+	opts, rest, err := parseLegacyOptions(300, 200, segments)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8080", "images", "1.jpg"}, rest)
+	assert.Equal(t, 60, opts.Quality)
+	assert.True(t, opts.Progressive)
+	assert.Equal(t, 30000, opts.MaxBytes)
+	assert.Equal(t, []processor.Operation{
+		processor.NewRotate(90),
+		processor.Resize{Type: processor.ResizeForce, Width: 300, Height: 200},
+		processor.Grayscale{},
+		processor.Blur(1.5),
+	}, opts.Operations)
+
+	_, _, err = parseLegacyOptions(300, 200, []string{"quality:high", "localhost", "1.jpg"})
+	assert.ErrorIs(t, err, processor.ErrInvalidOptions)
+}
+
+func TestParsePresetOptions(t *testing.T) {
+	thumb, err := processor.ParsePreset("thumb", "rs:fill:150:150/q:80")
+	require.NoError(t, err)
+
+	opts, rest, err := parsePresetOptions(thumb, []string{"localhost:8080", "images", "1.jpg"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, thumb, opts)
+	assert.Equal(t, []string{"localhost:8080", "images", "1.jpg"}, rest)
+
+	opts, rest, err = parsePresetOptions(thumb, []string{"quality:60", "plain", "localhost:8080", "1.jpg"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 60, opts.Quality)
+	assert.Equal(t, []string{"localhost:8080", "1.jpg"}, rest)
+
+	_, _, err = parsePresetOptions(thumb, []string{"quality:60", "localhost:8080", "1.jpg"}, true)
+	assert.ErrorIs(t, err, errPresetsOnly)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"imageproxy/internal/processor"
+	"imageproxy/internal/ratelimit"
+)
+
+// apiKeyHeader заголовок с API-ключом клиента.
+const apiKeyHeader = "X-API-Key"
+
+// clientLimits ограничения запросов клиента: частоты всех запросов
+// и промахов кэша, которые приводят к загрузке и обработке изображения,
+// и числа одновременных запросов.
+type clientLimits struct {
+	requests *ratelimit.Limiter
+	misses   *ratelimit.Limiter
+	inFlight *ratelimit.Concurrency
+	// apiClients имена клиентов по ключам API
+	apiClients map[string]string
+}
+
+// limit пропускает к next запросы клиента в пределах ограничений.
+func (l clientLimits) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := l.clientKey(r)
+		if err := l.requests.Allow(client); err != nil {
+			httpError(w, r, err)
+			return
+		}
+		release, err := l.inFlight.Acquire(client)
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		defer release()
+		ctx := processor.WithMissGuard(r.Context(), func() error {
+			return l.misses.Allow(client)
+		})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// clientKey определяет клиента по ключу подписи, API-ключу или IP-адресу.
+// Неизвестный API-ключ не учитывается, иначе клиент мог бы получать новый
+// лимит, меняя ключ.
+func (l clientLimits) clientKey(r *http.Request) string {
+	if keyID := signingKeyID(r.Context()); keyID != "" {
+		return "key:" + keyID
+	}
+	if name, ok := l.apiClients[r.Header.Get(apiKeyHeader)]; ok {
+		return "api:" + name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
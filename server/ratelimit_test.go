@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"imageproxy/internal/ratelimit"
+)
+
+func TestClientLimits(t *testing.T) {
+	limits := clientLimits{requests: ratelimit.New(0.5, 2)}
+	handler := limits.limit(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/p/plain/host/1.jpg", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1001").Code)
+	rec := request("10.0.0.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	// Другой клиент не ограничен
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1000").Code)
+
+	// Произвольный API-ключ не обходит ограничение
+	r := httptest.NewRequest(http.MethodGet, "/p/plain/host/1.jpg", nil)
+	r.RemoteAddr = "10.0.0.1:1003"
+	r.Header.Set(apiKeyHeader, "k1")
+	rec = httptest.NewRecorder()
+	handler(rec, r)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestClientLimits_Concurrency(t *testing.T) {
+	limits := clientLimits{inFlight: ratelimit.NewConcurrency(1)}
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := limits.limit(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	done := make(chan int)
+	go func() { done <- request("/slow", "10.0.0.1:1000").Code }()
+	<-started
+
+	// Клиент уже выполняет разрешенное число запросов
+	rec := request("/fast", "10.0.0.1:1001")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request("/fast", "10.0.0.2:1000").Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, request("/fast", "10.0.0.1:1002").Code)
+}
+
+func TestClientKey(t *testing.T) {
+	limits := clientLimits{apiClients: map[string]string{"secret": "backend"}}
+	r := httptest.NewRequest(http.MethodGet, "/p/plain/host/1.jpg", nil)
+	r.RemoteAddr = "10.0.0.1:12345"
+	assert.Equal(t, "ip:10.0.0.1", limits.clientKey(r))
+
+	// Неизвестный ключ не дает клиенту отдельного лимита
+	r.Header.Set(apiKeyHeader, "k1")
+	assert.Equal(t, "ip:10.0.0.1", limits.clientKey(r))
+
+	r.Header.Set(apiKeyHeader, "secret")
+	assert.Equal(t, "api:backend", limits.clientKey(r))
+
+	r = r.WithContext(context.WithValue(r.Context(), keyIDKey{}, "main"))
+	assert.Equal(t, "key:main", limits.clientKey(r))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"imageproxy/internal/config"
+	"imageproxy/internal/processor"
+	"imageproxy/internal/ratelimit"
+	"imageproxy/pkg/urlsign"
+)
+
+// liveSettings настройки сервера, которые применяются без перезапуска.
+type liveSettings struct {
+	config      config.Config
+	signingKeys urlsign.KeySet
+	limits      clientLimits
+}
+
+// newLiveSettings готовит настройки из проверенной конфигурации cfg.
+// Ограничители с неизменными параметрами берутся из prev, чтобы
+// перезагрузка не сбрасывала накопленные клиентами счетчики.
+func newLiveSettings(cfg config.Config, prev *liveSettings) *liveSettings {
+	signingKeys, _ := cfg.SigningKeys()
+	settings := &liveSettings{config: cfg, signingKeys: signingKeys}
+	settings.limits.apiClients, _ = cfg.APIClients()
+
+	limits := cfg.Limits
+	if prev != nil && prev.config.Limits.RateLimit == limits.RateLimit && prev.config.Limits.RateBurst == limits.RateBurst {
+		settings.limits.requests = prev.limits.requests
+	} else {
+		settings.limits.requests = ratelimit.New(limits.RateLimit, limits.RateBurst)
+	}
+	if prev != nil && prev.config.Limits.MissRateLimit == limits.MissRateLimit && prev.config.Limits.MissRateBurst == limits.MissRateBurst {
+		settings.limits.misses = prev.limits.misses
+	} else {
+		settings.limits.misses = ratelimit.New(limits.MissRateLimit, limits.MissRateBurst)
+	}
+	if prev != nil && prev.config.Limits.ClientConcurrency == limits.ClientConcurrency {
+		settings.limits.inFlight = prev.limits.inFlight
+	} else {
+		settings.limits.inFlight = ratelimit.NewConcurrency(limits.ClientConcurrency)
+	}
+	return settings
+}
+
+// reloader хранит действующие настройки и заменяет их при перезагрузке
+// конфигурации.
+type reloader struct {
+	live atomic.Pointer[liveSettings]
+	// load читает конфигурацию заново
+	load func() (config.Config, error)
+	// apply передает новую конфигурацию обработчику изображений
+	apply func(processor.Config) error
+	// mu не дает перезагрузкам выполняться одновременно
+	mu sync.Mutex
+}
+
+func newReloader(cfg config.Config, load func() (config.Config, error), apply func(processor.Config) error) *reloader {
+	r := &reloader{load: load, apply: apply}
+	r.live.Store(newLiveSettings(cfg, nil))
+	return r
+}
+
+// settings возвращает действующие настройки.
+func (r *reloader) settings() *liveSettings {
+	return r.live.Load()
+}
+
+// protect пропускает к next запросы с действительной подписью в пределах
+// ограничений частоты по действующим настройкам.
+func (r *reloader) protect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s := r.settings()
+		requireSignature(s.signingKeys, s.limits.limit(next))(w, req)
+	}
+}
+
+// admin пропускает к next запросы с токеном администратора по действующим
+// настройкам.
+func (r *reloader) admin(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requireAdmin(r.settings().config.Security.AdminToken, next)(w, req)
+	}
+}
+
+// reload читает и проверяет конфигурацию и применяет ее. При ошибке
+// действующие настройки не меняются. Возвращает настройки, изменение
+// которых требует перезапуска.
+func (r *reloader) reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	current := r.settings()
+	next, ignored := config.Reloaded(current.config, next)
+	processorConfig, err := next.ProcessorConfig()
+	if err != nil {
+		return nil, fmt.Errorf("processor config: %w", err)
+	}
+	if err := r.apply(processorConfig); err != nil {
+		return nil, fmt.Errorf("apply processor config: %w", err)
+	}
+	level, _ := next.LogLevel()
+	logLevel.Set(level)
+	r.live.Store(newLiveSettings(next, current))
+	return ignored, nil
+}
+
+// reloadAndLog перезагружает конфигурацию и сообщает о результате.
+func (r *reloader) reloadAndLog() {
+	ignored, err := r.reload()
+	if err != nil {
+		slog.Error("Config reload failed, keeping current config", "error", err)
+		return
+	}
+	slog.Info("Config reloaded")
+	if len(ignored) > 0 {
+		slog.Warn("Config changes require restart", "keys", ignored)
+	}
+}
+
+// run перезагружает конфигурацию по SIGHUP, а при заданных file и interval
+// также при изменении файла. Возвращается после отмены ctx.
+func (r *reloader) run(ctx context.Context, file string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	var modTime time.Time
+	if file != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		modTime = fileModTime(file)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			// Файл проверяется по времени изменения: так не нужны
+			// зависимости для уведомлений файловой системы
+			current := fileModTime(file)
+			if current.Equal(modTime) {
+				continue
+			}
+			modTime = current
+		}
+		r.reloadAndLog()
+	}
+}
+
+// fileModTime возвращает время изменения файла или нулевое время,
+// если файл недоступен.
+func fileModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"imageproxy/internal/config"
+	"imageproxy/internal/processor"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.RateLimit = 1
+	cfg.Limits.RateBurst = 1
+	cfg.Limits.ClientConcurrency = 2
+
+	next := cfg
+	var loadErr error
+	var applied []processor.Config
+	r := newReloader(cfg, func() (config.Config, error) {
+		return next, loadErr
+	}, func(pc processor.Config) error {
+		applied = append(applied, pc)
+		return nil
+	})
+	initial := r.settings()
+
+	t.Run("Invalid config keeps current", func(t *testing.T) {
+		loadErr = errors.New("bad config")
+		_, err := r.reload()
+		require.Error(t, err)
+		assert.Same(t, initial, r.settings())
+		assert.Empty(t, applied)
+		loadErr = nil
+	})
+
+	t.Run("Live settings are applied", func(t *testing.T) {
+		next.Server.PresetsOnly = true
+		next.Processing.Presets = map[string]string{"thumb": "rs:fill:100:100"}
+		next.Security.SigningKeys = map[string]string{"k1": "00112233"}
+		ignored, err := r.reload()
+		require.NoError(t, err)
+		assert.Empty(t, ignored)
+
+		s := r.settings()
+		assert.True(t, s.config.Server.PresetsOnly)
+		assert.Contains(t, s.signingKeys, "k1")
+		require.Len(t, applied, 1)
+		assert.Contains(t, applied[0].Presets, "thumb")
+		// Ограничители с прежними параметрами сохраняются
+		assert.Same(t, initial.limits.requests, s.limits.requests)
+		assert.Same(t, initial.limits.inFlight, s.limits.inFlight)
+	})
+
+	t.Run("Static settings require restart", func(t *testing.T) {
+		next.Server.Port = cfg.Server.Port + 1
+		next.Limits.RateLimit = 2
+		ignored, err := r.reload()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"server.port"}, ignored)
+
+		s := r.settings()
+		assert.Equal(t, cfg.Server.Port, s.config.Server.Port)
+		assert.NotSame(t, initial.limits.requests, s.limits.requests)
+	})
+}
+
+func TestReloader_Protect(t *testing.T) {
+	cfg := config.Default()
+	next := cfg
+	r := newReloader(cfg, func() (config.Config, error) { return next, nil },
+		func(processor.Config) error { return nil })
+	handler := r.protect(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	get := func() int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/p/rs:fit:10:10/plain/img.jpg", nil))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get())
+
+	// Новые ключи подписи действуют для уже зарегистрированного обработчика
+	next.Security.SigningKeys = map[string]string{"k1": "00112233"}
+	_, err := r.reload()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, get())
+}
+
+func TestReloader_RunWatchesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("server:\n  port: 8080\n"), 0o644))
+
+	var loads atomic.Int32
+	cfg := config.Default()
+	r := newReloader(cfg, func() (config.Config, error) {
+		loads.Add(1)
+		return cfg, nil
+	}, func(processor.Config) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx, file, 10*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, loads.Load(), "unchanged file must not be reloaded")
+
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+	require.Eventually(t, func() bool { return loads.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
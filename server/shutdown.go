@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	Storage "imageproxy/internal/storage"
+)
+
+// healthHandler отвечает 503, пока сервер останавливается, чтобы
+// балансировщик перестал направлять на него запросы.
+func healthHandler(draining *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// shutdown останавливает сервер: переводит /health в состояние ошибки,
+// ждет delay, перестает принимать соединения, дожидается завершения начатых
+// запросов не дольше timeout и закрывает хранилище. Закрытие хранилища
+// дожидается записей в кэш, которые начали обработчики, не завершившиеся
+// за timeout.
+func shutdown(server *http.Server, draining *atomic.Bool, storage Storage.Storage, delay, timeout time.Duration) error {
+	draining.Store(true)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain requests: %w", err))
+	}
+	if err := storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close storage: %w", err))
+	}
+	return errors.Join(errs...)
+}
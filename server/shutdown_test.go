@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	Storage "imageproxy/internal/storage"
+)
+
+func TestShutdown(t *testing.T) {
+	var draining atomic.Bool
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler(&draining))
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second}
+	go func() { _ = server.Serve(listener) }()
+	base := "http://" + listener.Addr().String()
+
+	get := func(path string) int {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, base+path, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("/health"))
+
+	slow := make(chan int)
+	go func() { slow <- get("/slow") }()
+	<-started
+
+	storage := Storage.NewMemoryStorage()
+	done := make(chan error)
+	go func() { done <- shutdown(server, &draining, storage, 100*time.Millisecond, time.Second) }()
+
+	require.Eventually(t, draining.Load, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/health"))
+
+	// Начатый запрос завершается до остановки
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slow)
+	require.NoError(t, <-done)
+	assert.ErrorIs(t, storage.Set(context.Background(), "key", nil), Storage.ErrClosed)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"imageproxy/pkg/urlsign"
+)
+
+type keyIDKey struct{}
+
+// signingKeyID возвращает идентификатор ключа, которым подписан запрос.
+func signingKeyID(ctx context.Context) string {
+	keyID, _ := ctx.Value(keyIDKey{}).(string)
+	return keyID
+}
+
+// requireSignature пропускает к next только запросы с действительной
+// подписью. Пустой набор ключей отключает проверку.
+func requireSignature(keys urlsign.KeySet, next http.HandlerFunc) http.HandlerFunc {
+	if len(keys) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyID, err := keys.Verify(r.URL.Path, r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), keyIDKey{}, keyID)))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"imageproxy/pkg/urlsign"
+)
+
+func TestRequireSignature(t *testing.T) {
+	keys := urlsign.KeySet{"main": []byte("secret")}
+	ok := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	handler := requireSignature(keys, ok)
+	path := "/preset/thumb/localhost:8080/images/1.jpg"
+
+	testCases := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"Signed", urlsign.Sign(path, "main", keys["main"], time.Time{}), http.StatusOK},
+		{"Not expired", urlsign.Sign(path, "main", keys["main"], time.Now().Add(time.Hour)), http.StatusOK},
+		{"Expired", urlsign.Sign(path, "main", keys["main"], time.Now().Add(-time.Hour)), http.StatusForbidden},
+		{"Unknown key", urlsign.Sign(path, "other", []byte("secret"), time.Time{}), http.StatusForbidden},
+		{"Missing", path, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+
+	// Без ключей проверка отключена
+	rec := httptest.NewRecorder()
+	requireSignature(nil, ok)(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}